@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"PinkTide/internal/metrics"
 	"PinkTide/internal/origin"
+	"PinkTide/internal/trace"
 )
 
 // Client 负责调用 B 站直播 API 获取可用流地址。
@@ -20,56 +23,109 @@ func NewClient(originClient *origin.Client) *Client {
 	return &Client{originClient: originClient}
 }
 
-// FetchPlayURL 根据房间号获取可播放 URL，失败返回错误。
-func (c *Client) FetchPlayURL(ctx context.Context, roomID string) (string, error) {
+// FetchPlayURL 根据房间号与播放偏好获取可播放地址：按协议、封装与编码偏好选择候选流，
+// qn 不受支持时回退到最接近的可用档位。
+func (c *Client) FetchPlayURL(ctx context.Context, roomID string, opts PlayOptions) (info PlayInfo, err error) {
+	ctx, span := trace.Start(ctx, "bili.FetchPlayURL", trace.KindInternal)
+	defer func() {
+		span.SetAttr("bili.qn", info.Qn)
+		span.SetAttr("bili.protocol", info.Protocol)
+		span.SetAttr("bili.format", info.Format)
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttr("room_id", roomID)
+	span.SetAttr("play_options", opts.String())
+
+	if opts.Qn <= 0 {
+		opts.Qn = DefaultQn
+	}
+	info, err = c.fetchPlayURL(ctx, roomID, opts)
+	if err != nil {
+		return PlayInfo{}, err
+	}
+	if info.Qn == opts.Qn || len(info.Qualities) == 0 || hasQuality(info.Qualities, opts.Qn) {
+		return info, nil
+	}
+	nearest := nearestQn(info.Qualities, opts.Qn)
+	if nearest == 0 || nearest == info.Qn {
+		return info, nil
+	}
+	opts.Qn = nearest
+	return c.fetchPlayURL(ctx, roomID, opts)
+}
+
+// fetchPlayURL 以指定清晰度调用 getRoomPlayInfo 接口，请求全部协议、封装与编码后按偏好选择。
+func (c *Client) fetchPlayURL(ctx context.Context, roomID string, opts PlayOptions) (PlayInfo, error) {
 	if roomID == "" {
-		return "", fmt.Errorf("room id is empty")
+		return PlayInfo{}, fmt.Errorf("room id is empty")
 	}
 
 	apiURL := fmt.Sprintf(
-		"https://api.live.bilibili.com/xlive/web-room/v1/playUrl/playUrl?cid=%s&platform=h5&qn=10000&https_url_req=1&ptype=16",
+		"https://api.live.bilibili.com/xlive/web-room/v2/index/getRoomPlayInfo?room_id=%s&protocol=0,1&format=0,1,2&codec=0,1&qn=%d&platform=web&ptype=8",
 		url.QueryEscape(roomID),
+		opts.Qn,
 	)
 
 	data, status, err := c.originClient.Get(ctx, apiURL)
 	if err != nil {
-		return "", err
+		apiError(apiPlayURL, "network")
+		return PlayInfo{}, err
 	}
 	if status != 200 {
-		return "", fmt.Errorf("api status %d", status)
+		apiError(apiPlayURL, "http_"+strconv.Itoa(status))
+		return PlayInfo{}, fmt.Errorf("api status %d", status)
 	}
 
 	var result apiResponse
 	if err := json.Unmarshal(data, &result); err != nil {
-		return "", fmt.Errorf("decode response failed: %w", err)
-	}
-
-	streamList := result.Data.PlayUrlInfo.PlayUrl.Stream
-	if len(streamList) > 0 {
-		formatList := streamList[0].Format
-		if len(formatList) > 0 {
-			codecList := formatList[0].Codec
-			if len(codecList) > 0 {
-				urlInfoList := codecList[0].UrlInfo
-				if len(urlInfoList) > 0 {
-					fullURL := urlInfoList[0].Host + codecList[0].BaseUrl + urlInfoList[0].Extra
-					return fullURL, nil
-				}
-			}
-		}
+		apiError(apiPlayURL, "decode")
+		return PlayInfo{}, fmt.Errorf("decode response failed: %w", err)
+	}
+	if result.Code != 0 {
+		apiError(apiPlayURL, strconv.Itoa(result.Code))
+	}
+
+	playURL := result.Data.PlayUrlInfo.PlayUrl
+	if candidate, ok := SelectStream(playURL.Stream, opts); ok {
+		return PlayInfo{
+			URL:       candidate.URL(),
+			Qn:        candidate.Codec.CurrentQn,
+			Qualities: acceptedQualities(candidate.Codec.AcceptQn, playURL.QnDesc),
+			Protocol:  candidate.Protocol,
+			Format:    candidate.Format,
+			Codec:     candidate.Codec.Name,
+			Expires:   playURLExpiry(candidate.URL()),
+		}, nil
 	}
 
 	if len(result.Data.Durl) > 0 {
 		rawURL := result.Data.Durl[0].URL
 		if rawURL != "" {
-			return rawURL, nil
+			return PlayInfo{
+				URL:       rawURL,
+				Qn:        result.Data.CurrentQn,
+				Qualities: acceptedQualities(nil, result.Data.QualityDescription),
+				Protocol:  ProtocolStream,
+				Format:    FormatFLV,
+				Expires:   playURLExpiry(rawURL),
+			}, nil
 		}
 	}
 
-	return "", fmt.Errorf("play url not found")
+	return PlayInfo{}, fmt.Errorf("play url not found")
 }
 
-func (c *Client) FetchRoomStatus(ctx context.Context, roomID string) (RoomStatus, error) {
+// FetchRoomStatus 调用 room_init 查询房间开播、封禁与短号信息。
+func (c *Client) FetchRoomStatus(ctx context.Context, roomID string) (status RoomStatus, err error) {
+	ctx, span := trace.Start(ctx, "bili.FetchRoomStatus", trace.KindInternal)
+	defer func() {
+		span.SetAttr("bili.live_status", status.LiveStatus)
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttr("room_id", roomID)
+
 	if roomID == "" {
 		return RoomStatus{}, fmt.Errorf("room id is empty")
 	}
@@ -79,19 +135,23 @@ func (c *Client) FetchRoomStatus(ctx context.Context, roomID string) (RoomStatus
 		url.QueryEscape(roomID),
 	)
 
-	data, status, err := c.originClient.Get(ctx, apiURL)
+	data, code, err := c.originClient.Get(ctx, apiURL)
 	if err != nil {
+		apiError(apiRoomInit, "network")
 		return RoomStatus{}, err
 	}
-	if status != 200 {
-		return RoomStatus{}, fmt.Errorf("api status %d", status)
+	if code != 200 {
+		apiError(apiRoomInit, "http_"+strconv.Itoa(code))
+		return RoomStatus{}, fmt.Errorf("api status %d", code)
 	}
 
 	var result roomInitResponse
 	if err := json.Unmarshal(data, &result); err != nil {
+		apiError(apiRoomInit, "decode")
 		return RoomStatus{}, fmt.Errorf("decode response failed: %w", err)
 	}
 	if result.Code != 0 {
+		apiError(apiRoomInit, strconv.Itoa(result.Code))
 		msg := strings.TrimSpace(result.Message)
 		if msg == "" {
 			msg = strings.TrimSpace(result.Msg)
@@ -112,31 +172,41 @@ func (c *Client) FetchRoomStatus(ctx context.Context, roomID string) (RoomStatus
 	}, nil
 }
 
+// 接口名称，用于错误计数的 api 标签。
+const (
+	apiPlayURL  = "getRoomPlayInfo"
+	apiRoomInit = "room_init"
+)
+
+// apiError 按接口与错误码计数。
+func apiError(api, code string) {
+	metrics.BiliAPIErrors.Inc(api, code)
+}
+
 // apiResponse 对齐 B 站 API 返回结构，仅保留必要字段。
 type apiResponse struct {
 	Code int `json:"code"`
 	Data struct {
 		PlayUrlInfo struct {
 			PlayUrl struct {
-				Stream []struct {
-					Format []struct {
-						Codec []struct {
-							BaseUrl string `json:"base_url"`
-							UrlInfo []struct {
-								Host  string `json:"host"`
-								Extra string `json:"extra"`
-							} `json:"url_info"`
-						} `json:"codec"`
-					} `json:"format"`
-				} `json:"stream"`
+				Stream []Stream `json:"stream"`
+				QnDesc []qnDesc `json:"g_qn_desc"`
 			} `json:"play_url"`
 		} `json:"playurl_info"`
-		Durl []struct {
+		CurrentQn          int      `json:"current_qn"`
+		QualityDescription []qnDesc `json:"quality_description"`
+		Durl               []struct {
 			URL string `json:"url"`
 		} `json:"durl"`
 	} `json:"data"`
 }
 
+// qnDesc 为接口返回的清晰度描述。
+type qnDesc struct {
+	Qn   int    `json:"qn"`
+	Desc string `json:"desc"`
+}
+
 type roomInitResponse struct {
 	Code    int    `json:"code"`
 	Msg     string `json:"msg"`
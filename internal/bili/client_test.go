@@ -0,0 +1,105 @@
+package bili
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNearestQn(t *testing.T) {
+	qualities := []Quality{{Qn: 10000}, {Qn: 400}, {Qn: 250}, {Qn: 150}}
+	cases := []struct {
+		name string
+		qn   int
+		want int
+	}{
+		{name: "exact", qn: 250, want: 250},
+		{name: "between", qn: 300, want: 250},
+		{name: "above all", qn: 30000, want: 10000},
+		{name: "below all", qn: 80, want: 150},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nearestQn(qualities, tc.qn); got != tc.want {
+				t.Fatalf("unexpected qn: %d", got)
+			}
+		})
+	}
+	if got := nearestQn(nil, 10000); got != 0 {
+		t.Fatalf("expected 0 for empty list, got %d", got)
+	}
+}
+
+func TestSnapQn(t *testing.T) {
+	cases := map[int]int{1: 80, 80: 80, 149: 80, 150: 150, 399: 250, 10000: 10000, 10001: 10000, 99999: 30000}
+	for qn, want := range cases {
+		if got := SnapQn(qn); got != want {
+			t.Fatalf("SnapQn(%d) = %d, want %d", qn, got, want)
+		}
+	}
+}
+
+func TestAcceptedQualities(t *testing.T) {
+	desc := []qnDesc{{Qn: 10000, Desc: "原画"}, {Qn: 400, Desc: "蓝光"}, {Qn: 250, Desc: "超清"}, {Qn: 150, Desc: "高清"}}
+
+	got := acceptedQualities([]int{150, 10000}, desc)
+	want := []Quality{{Qn: 10000, Desc: "原画"}, {Qn: 150, Desc: "高清"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected qualities: %+v", got)
+	}
+
+	if got := acceptedQualities(nil, desc); len(got) != len(desc) {
+		t.Fatalf("expected all described qualities, got %+v", got)
+	}
+}
+
+func TestSelectStream(t *testing.T) {
+	node := []URLInfo{{Host: "https://cn.bilivideo.com", Extra: "?k=v"}}
+	streams := []Stream{
+		{Protocol: ProtocolStream, Formats: []Format{
+			{Name: FormatFLV, Codecs: []Codec{{Name: CodecAVC, BaseURL: "/flv-avc", URLInfo: node}}},
+		}},
+		{Protocol: ProtocolHLS, Formats: []Format{
+			{Name: FormatTS, Codecs: []Codec{{Name: CodecAVC, BaseURL: "/ts-avc", URLInfo: node}}},
+			{Name: FormatFMP4, Codecs: []Codec{
+				{Name: CodecAVC, BaseURL: "/fmp4-avc", URLInfo: node},
+				{Name: CodecHEVC, BaseURL: "/fmp4-hevc", URLInfo: node},
+			}},
+		}},
+	}
+	cases := []struct {
+		name string
+		opts PlayOptions
+		want string
+	}{
+		{name: "exact", opts: PlayOptions{Protocol: ProtocolHLS, Format: FormatFMP4, Codec: CodecHEVC}, want: "/fmp4-hevc"},
+		{name: "relax codec", opts: PlayOptions{Protocol: ProtocolHLS, Format: FormatTS, Codec: CodecHEVC}, want: "/ts-avc"},
+		{name: "relax format", opts: PlayOptions{Protocol: ProtocolStream, Format: FormatTS, Codec: CodecAVC}, want: "/flv-avc"},
+		{name: "relax protocol", opts: PlayOptions{Protocol: ProtocolStream, Format: FormatFMP4, Codec: CodecHEVC}, want: "/flv-avc"},
+		{name: "any", opts: PlayOptions{}, want: "/flv-avc"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := SelectStream(streams, tc.opts)
+			if !ok || got.Codec.BaseURL != tc.want {
+				t.Fatalf("unexpected candidate: %+v", got)
+			}
+		})
+	}
+	if got, _ := SelectStream(streams, PlayOptions{Protocol: ProtocolHLS, Format: FormatFMP4, Codec: CodecHEVC}); got.URL() != "https://cn.bilivideo.com/fmp4-hevc?k=v" {
+		t.Fatalf("unexpected url: %s", got.URL())
+	}
+	if _, ok := SelectStream(nil, PlayOptions{}); ok {
+		t.Fatalf("expected no candidate")
+	}
+}
+
+func TestPlayURLExpiry(t *testing.T) {
+	got := playURLExpiry("https://cn.bilivideo.com/live/index.m3u8?expires=1767225600&len=0&oi=1")
+	if !got.Equal(time.Unix(1767225600, 0)) {
+		t.Fatalf("unexpected expiry: %v", got)
+	}
+	if got := playURLExpiry("https://cn.bilivideo.com/live/index.m3u8?len=0"); !got.IsZero() {
+		t.Fatalf("expected zero expiry, got %v", got)
+	}
+}
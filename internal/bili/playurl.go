@@ -0,0 +1,225 @@
+package bili
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultQn 为未指定清晰度时请求的原画档位。
+const DefaultQn = 10000
+
+// 协议、封装与编码取值，与 playurl_info 中的 protocol_name、format_name、codec_name 一致。
+const (
+	ProtocolStream = "http_stream"
+	ProtocolHLS    = "http_hls"
+	FormatFLV      = "flv"
+	FormatTS       = "ts"
+	FormatFMP4     = "fmp4"
+	CodecAVC       = "avc"
+	CodecHEVC      = "hevc"
+)
+
+// Quality 描述一个清晰度档位。
+type Quality struct {
+	Qn   int    `json:"qn"`
+	Desc string `json:"desc"`
+}
+
+// PlayOptions 描述播放偏好，协议、封装与编码为空时表示不限。
+type PlayOptions struct {
+	Qn       int
+	Protocol string
+	Format   string
+	Codec    string
+}
+
+// String 生成稳定的偏好描述，用于缓存键与日志。
+func (o PlayOptions) String() string {
+	return strconv.Itoa(o.Qn) + "/" + o.Protocol + "/" + o.Format + "/" + o.Codec
+}
+
+// Validate 校验协议、封装与编码取值。
+func (o PlayOptions) Validate() error {
+	switch o.Protocol {
+	case "", ProtocolStream, ProtocolHLS:
+	default:
+		return fmt.Errorf("unsupported protocol: %s", o.Protocol)
+	}
+	switch o.Format {
+	case "", FormatFLV, FormatTS, FormatFMP4:
+	default:
+		return fmt.Errorf("unsupported format: %s", o.Format)
+	}
+	switch o.Codec {
+	case "", CodecAVC, CodecHEVC:
+	default:
+		return fmt.Errorf("unsupported codec: %s", o.Codec)
+	}
+	return nil
+}
+
+// PlayInfo 为一次播放地址查询结果，Qualities 为所选编码当前可选清晰度，
+// Expires 为地址鉴权参数中的过期时间，地址未携带 expires 时为零值。
+type PlayInfo struct {
+	URL       string
+	Qn        int
+	Qualities []Quality
+	Protocol  string
+	Format    string
+	Codec     string
+	Expires   time.Time
+}
+
+// playURLExpiry 读取播放地址查询串中的 expires（Unix 秒），缺失或无法解析时返回零值。
+func playURLExpiry(rawURL string) time.Time {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// Stream 对应 playurl_info.play_url.stream 中的一个协议。
+type Stream struct {
+	Protocol string   `json:"protocol_name"`
+	Formats  []Format `json:"format"`
+}
+
+// Format 对应协议下的一种封装。
+type Format struct {
+	Name   string  `json:"format_name"`
+	Codecs []Codec `json:"codec"`
+}
+
+// Codec 对应封装下的一种编码及其可用地址。
+type Codec struct {
+	Name      string    `json:"codec_name"`
+	BaseURL   string    `json:"base_url"`
+	CurrentQn int       `json:"current_qn"`
+	AcceptQn  []int     `json:"accept_qn"`
+	URLInfo   []URLInfo `json:"url_info"`
+}
+
+// URLInfo 为编码的一个 CDN 节点，Extra 为带鉴权参数的查询串。
+type URLInfo struct {
+	Host      string `json:"host"`
+	Extra     string `json:"extra"`
+	StreamTTL int    `json:"stream_ttl"`
+}
+
+// Candidate 为选中的协议、封装与编码组合。
+type Candidate struct {
+	Protocol string
+	Format   string
+	Codec    Codec
+}
+
+// URL 拼接首个 CDN 节点的完整播放地址。
+func (c Candidate) URL() string {
+	info := c.Codec.URLInfo[0]
+	return info.Host + c.Codec.BaseURL + info.Extra
+}
+
+// SelectStream 按偏好选择候选流：优先完全匹配，缺失时依次放宽编码、封装与协议，
+// 同等匹配程度下保持接口返回顺序；没有可用地址时返回 false。
+func SelectStream(streams []Stream, opts PlayOptions) (Candidate, bool) {
+	var (
+		best      Candidate
+		bestScore = -1
+	)
+	for _, stream := range streams {
+		for _, format := range stream.Formats {
+			for _, codec := range format.Codecs {
+				if len(codec.URLInfo) == 0 {
+					continue
+				}
+				score := 0
+				if opts.Protocol == "" || opts.Protocol == stream.Protocol {
+					score += 4
+				}
+				if opts.Format == "" || opts.Format == format.Name {
+					score += 2
+				}
+				if opts.Codec == "" || opts.Codec == codec.Name {
+					score++
+				}
+				if score > bestScore {
+					best = Candidate{Protocol: stream.Protocol, Format: format.Name, Codec: codec}
+					bestScore = score
+				}
+			}
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// acceptedQualities 以 accept_qn 为准筛选清晰度描述，accept_qn 为空时返回全部描述。
+func acceptedQualities(accept []int, desc []qnDesc) []Quality {
+	names := make(map[int]string, len(desc))
+	for _, d := range desc {
+		names[d.Qn] = d.Desc
+	}
+	var qualities []Quality
+	if len(accept) > 0 {
+		for _, qn := range accept {
+			qualities = append(qualities, Quality{Qn: qn, Desc: names[qn]})
+		}
+	} else {
+		for _, d := range desc {
+			qualities = append(qualities, Quality{Qn: d.Qn, Desc: d.Desc})
+		}
+	}
+	sort.Slice(qualities, func(i, j int) bool { return qualities[i].Qn > qualities[j].Qn })
+	return qualities
+}
+
+// knownQualities 为接口已知的清晰度档位，从高到低排列。
+var knownQualities = []Quality{
+	{Qn: 30000, Desc: "杜比"},
+	{Qn: 20000, Desc: "4K"},
+	{Qn: 10000, Desc: "原画"},
+	{Qn: 400, Desc: "蓝光"},
+	{Qn: 250, Desc: "超清"},
+	{Qn: 150, Desc: "高清"},
+	{Qn: 80, Desc: "流畅"},
+}
+
+// SnapQn 将请求档位归一为不高于它的最高已知档位，全部高于时取最低档位，
+// 使任意取值只会落到有限的几组播放偏好上。
+func SnapQn(qn int) int {
+	return nearestQn(knownQualities, qn)
+}
+
+// hasQuality 判断清晰度是否在可选列表中。
+func hasQuality(qualities []Quality, qn int) bool {
+	for _, q := range qualities {
+		if q.Qn == qn {
+			return true
+		}
+	}
+	return false
+}
+
+// nearestQn 选取不高于请求档位的最高可用清晰度，全部高于请求时选取最低档位。
+func nearestQn(qualities []Quality, qn int) int {
+	best, lowest := 0, 0
+	for _, q := range qualities {
+		if q.Qn <= qn && q.Qn > best {
+			best = q.Qn
+		}
+		if lowest == 0 || q.Qn < lowest {
+			lowest = q.Qn
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	return lowest
+}
@@ -0,0 +1,114 @@
+package bili
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"PinkTide/internal/metrics"
+)
+
+// sweepThreshold 为触发过期条目清理的缓存条目数。
+const sweepThreshold = 256
+
+// StatusCache 缓存 room_init 结果并合并并发请求，开播与未开播结果使用不同 TTL，失败结果不缓存。
+type StatusCache struct {
+	fetch      func(ctx context.Context, roomID string) (RoomStatus, error)
+	liveTTL    time.Duration
+	offlineTTL time.Duration
+	group      singleflight.Group
+	mu         sync.Mutex
+	entries    map[string]statusEntry
+	now        func() time.Time
+}
+
+// statusEntry 记录缓存的房间状态与过期时间。
+type statusEntry struct {
+	status    RoomStatus
+	expiresAt time.Time
+}
+
+// NewStatusCache 创建房间状态缓存，liveTTL 用于直播中结果，offlineTTL 用于未开播、轮播、封禁等结果。
+func NewStatusCache(client *Client, liveTTL, offlineTTL time.Duration) *StatusCache {
+	return &StatusCache{
+		fetch:      client.FetchRoomStatus,
+		liveTTL:    liveTTL,
+		offlineTTL: offlineTTL,
+		entries:    make(map[string]statusEntry),
+		now:        time.Now,
+	}
+}
+
+// Get 返回房间状态，缓存有效时直接返回，否则合并并发请求后调用 room_init。
+func (c *StatusCache) Get(ctx context.Context, roomID string) (RoomStatus, error) {
+	if status, ok := c.lookup(roomID); ok {
+		return status, nil
+	}
+
+	value, err, shared := c.group.Do(roomID, func() (interface{}, error) {
+		if status, ok := c.lookup(roomID); ok {
+			return status, nil
+		}
+		// 合并后的请求不跟随首个调用方取消，避免其断开导致其余等待者失败。
+		status, err := c.fetch(context.WithoutCancel(ctx), roomID)
+		if err != nil {
+			return nil, err
+		}
+		c.store(roomID, status)
+		return status, nil
+	})
+	metrics.Flights.Inc("room_status", metrics.FlightResult(shared))
+	if err != nil {
+		return RoomStatus{}, err
+	}
+	status, ok := value.(RoomStatus)
+	if !ok {
+		return RoomStatus{}, fmt.Errorf("invalid response type")
+	}
+	return status, nil
+}
+
+// lookup 读取未过期的缓存条目。
+func (c *StatusCache) lookup(roomID string) (RoomStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[roomID]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return RoomStatus{}, false
+	}
+	return entry.status, true
+}
+
+// store 按直播状态选择 TTL 写入缓存，同时以长号与短号为键，使同一房间的不同别名共享结果。
+func (c *StatusCache) store(roomID string, status RoomStatus) {
+	ttl := c.offlineTTL
+	if status.LiveStatus == 1 && !status.IsLocked && !status.IsHidden {
+		ttl = c.liveTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= sweepThreshold {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	entry := statusEntry{status: status, expiresAt: now.Add(ttl)}
+	c.entries[roomID] = entry
+	if status.RoomID > 0 {
+		c.entries[strconv.Itoa(status.RoomID)] = entry
+	}
+	if status.ShortID > 0 {
+		c.entries[strconv.Itoa(status.ShortID)] = entry
+	}
+}
@@ -0,0 +1,105 @@
+package bili
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatusCacheCoalescesAndExpires(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := &StatusCache{
+		fetch: func(ctx context.Context, roomID string) (RoomStatus, error) {
+			calls.Add(1)
+			<-release
+			return RoomStatus{RoomID: 23058, ShortID: 3, LiveStatus: 1}, nil
+		},
+		liveTTL:    30 * time.Second,
+		offlineTTL: 5 * time.Second,
+		entries:    make(map[string]statusEntry),
+		now:        func() time.Time { return now },
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := cache.Get(context.Background(), "3")
+			if err != nil || status.RoomID != 23058 {
+				t.Errorf("unexpected result: %+v, %v", status, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected coalesced call, got %d", n)
+	}
+	if status, err := cache.Get(context.Background(), "23058"); err != nil || status.ShortID != 3 {
+		t.Fatalf("expected long id alias to hit cache: %+v, %v", status, err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected alias lookup to be cached, got %d calls", n)
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, err := cache.Get(context.Background(), "3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected live result to be cached, got %d calls", n)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := cache.Get(context.Background(), "3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected refetch after live ttl, got %d calls", n)
+	}
+}
+
+func TestStatusCacheOfflineTTLAndErrors(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var calls atomic.Int32
+	fail := true
+	cache := &StatusCache{
+		fetch: func(ctx context.Context, roomID string) (RoomStatus, error) {
+			calls.Add(1)
+			if fail {
+				return RoomStatus{}, errors.New("api error")
+			}
+			return RoomStatus{RoomID: 1, LiveStatus: 0}, nil
+		},
+		liveTTL:    30 * time.Second,
+		offlineTTL: 5 * time.Second,
+		entries:    make(map[string]statusEntry),
+		now:        func() time.Time { return now },
+	}
+
+	if _, err := cache.Get(context.Background(), "1"); err == nil {
+		t.Fatalf("expected error")
+	}
+	fail = false
+	if _, err := cache.Get(context.Background(), "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected errors not to be cached, got %d calls", n)
+	}
+
+	now = now.Add(6 * time.Second)
+	if _, err := cache.Get(context.Background(), "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("expected refetch after offline ttl, got %d calls", n)
+	}
+}
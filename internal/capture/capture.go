@@ -0,0 +1,238 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"PinkTide/internal/playlist"
+	"PinkTide/internal/stream"
+)
+
+// PollInterval 为采集方检查直播播放列表更新的间隔，播放列表由后台轮询器维护，检查只读取内存。
+const PollInterval = 500 * time.Millisecond
+
+// ErrMasterPlaylist 表示源站返回主播放列表，无法直接采集切片。
+var ErrMasterPlaylist = errors.New("master playlist not supported")
+
+// segmentExts 为按源地址扩展名保留的切片后缀，其余按 .ts 保存。
+var segmentExts = map[string]bool{".ts": true, ".m4s": true, ".mp4": true, ".aac": true}
+
+// Fetch 下载切片或初始化段。
+type Fetch func(ctx context.Context, target string) ([]byte, error)
+
+// Segment 为一个已写入目录的切片，Index 为写入顺序（从 0 开始），Size 为文件字节数，MapFile 为其初始化段文件名。
+type Segment struct {
+	Index         int64
+	File          string
+	Size          int64
+	MapFile       string
+	Start         time.Time
+	Duration      time.Duration
+	Discontinuity bool
+}
+
+// Writer 跟踪直播播放列表的采集进度，把尚未采集的切片与初始化段按顺序写入目录；
+// 序号跳跃或回退、源站不连续标记、下载失败与初始化段变化均记为下一个切片前的不连续点。
+// Writer 不是并发安全的，只应由单个采集协程使用。
+type Writer struct {
+	dir    string
+	layout string
+	fetch  Fetch
+	warn   func(msg string, args ...any)
+
+	started       bool
+	nextMSN       int64
+	discontinuity bool
+	mapTarget     string
+	mapFile       string
+	maps          int
+	written       int64
+	end           time.Time
+}
+
+// NewWriter 创建写入 dir 的采集器，layout 为切片文件名中写入序号的格式（如 %06d），
+// warn 接收采集过程中的告警，可为 nil。
+func NewWriter(dir, layout string, fetch Fetch, warn func(msg string, args ...any)) *Writer {
+	if warn == nil {
+		warn = func(string, ...any) {}
+	}
+	return &Writer{dir: dir, layout: layout, fetch: fetch, warn: warn}
+}
+
+// Update 写入 snap 中尚未采集的切片，每写入一个切片调用一次 emit，emit 返回错误时停止；
+// 返回解析后的播放列表，供调用方读取结束标记等信息。
+//
+// 切片起始时间优先采用源站 EXT-X-PROGRAM-DATE-TIME；缺失时接续上一个切片，
+// 不连续点后或首个切片按拉取时间减去其后切片的总时长推算。
+func (w *Writer) Update(ctx context.Context, snap *stream.Snapshot, emit func(Segment) error) (*playlist.Playlist, error) {
+	pl := playlist.Parse(snap.Content)
+	if pl.Master {
+		return pl, ErrMasterPlaylist
+	}
+	base, err := url.Parse(snap.OriginBase)
+	if err != nil {
+		return pl, err
+	}
+
+	// 最新切片序号落后于已采集位置，视为源站重启并从当前窗口重新开始。
+	if last := pl.MediaSequence + int64(len(pl.Segments)) - 1; w.started && len(pl.Segments) > 0 && last < w.nextMSN-1 {
+		w.warn("sequence reset", "from", w.nextMSN, "to", pl.MediaSequence)
+		w.nextMSN = pl.MediaSequence
+		w.discontinuity = true
+	}
+
+	// remaining[i] 为第 i 个及之后切片的总时长。
+	remaining := make([]time.Duration, len(pl.Segments)+1)
+	for i := len(pl.Segments) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + pl.Segments[i].Duration
+	}
+
+	for i, seg := range pl.Segments {
+		msn := seg.Sequence
+		if w.started && msn < w.nextMSN {
+			continue
+		}
+		if w.started && msn > w.nextMSN {
+			w.warn("segment gap", "missed", msn-w.nextMSN, "msn", msn)
+			w.discontinuity = true
+		}
+		w.started = true
+		w.nextMSN = msn + 1
+		if seg.Discontinuity {
+			w.discontinuity = true
+		}
+
+		if seg.Map != nil {
+			if err := w.writeMap(ctx, resolve(base, seg.MapURI())); err != nil {
+				if ctx.Err() != nil {
+					return pl, ctx.Err()
+				}
+				w.warn("init segment failed", "error", err)
+				w.discontinuity = true
+				continue
+			}
+		}
+
+		data, err := w.fetch(ctx, resolve(base, seg.URI))
+		if err != nil {
+			if ctx.Err() != nil {
+				return pl, ctx.Err()
+			}
+			w.warn("segment failed", "msn", msn, "error", err)
+			w.discontinuity = true
+			continue
+		}
+		start := seg.ProgramDateTime
+		if start.IsZero() {
+			if w.discontinuity || w.end.IsZero() {
+				start = snap.FetchedAt.Add(-remaining[i])
+			} else {
+				start = w.end
+			}
+		}
+		w.end = start.Add(seg.Duration)
+
+		name := fmt.Sprintf(w.layout, w.written) + segmentExt(seg.URI)
+		if err := os.WriteFile(filepath.Join(w.dir, name), data, 0o644); err != nil {
+			return pl, err
+		}
+		// 首个切片之前没有内容，不连续标记没有意义。
+		out := Segment{
+			Index:         w.written,
+			File:          name,
+			Size:          int64(len(data)),
+			MapFile:       w.mapFile,
+			Start:         start,
+			Duration:      seg.Duration,
+			Discontinuity: w.discontinuity && w.written > 0,
+		}
+		w.written++
+		w.discontinuity = false
+		if err := emit(out); err != nil {
+			return pl, err
+		}
+	}
+	return pl, nil
+}
+
+// writeMap 在初始化段地址变化时下载并保存新的初始化段。
+func (w *Writer) writeMap(ctx context.Context, target string) error {
+	if target == w.mapTarget {
+		return nil
+	}
+	data, err := w.fetch(ctx, target)
+	if err != nil {
+		return err
+	}
+	w.maps++
+	name := fmt.Sprintf("init-%d.mp4", w.maps)
+	if err := os.WriteFile(filepath.Join(w.dir, name), data, 0o644); err != nil {
+		return err
+	}
+	if w.mapFile != "" {
+		w.discontinuity = true
+	}
+	w.mapTarget, w.mapFile = target, name
+	return nil
+}
+
+// Playlist 以切片文件名生成媒体播放列表，地址相对于播放列表所在目录：含初始化段时版本为 6，
+// 目标时长取最长切片，不连续点与初始化段变化标注在对应切片前；playlistType 为空时省略
+// EXT-X-PLAYLIST-TYPE，调用方可继续设置结束标记等。
+func Playlist(segments []Segment, playlistType string) *playlist.Playlist {
+	pl := playlist.NewMedia()
+	version := 3
+	var target time.Duration
+	for _, seg := range segments {
+		target = max(target, seg.Duration)
+		if seg.MapFile != "" {
+			version = 6
+		}
+	}
+	pl.SetVersion(version)
+	if playlistType != "" {
+		pl.SetPlaylistType(playlistType)
+	}
+	pl.SetTargetDuration(target)
+	if len(segments) > 0 {
+		pl.SetMediaSequence(segments[0].Index)
+	}
+	mapFile := ""
+	for _, seg := range segments {
+		out := pl.AppendSegment(seg.File, seg.Duration)
+		out.SetDiscontinuity(seg.Discontinuity)
+		if seg.MapFile != mapFile {
+			out.SetMap(seg.MapFile)
+			mapFile = seg.MapFile
+		}
+	}
+	return pl
+}
+
+// resolve 将播放列表中的相对地址解析为绝对地址。
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// segmentExt 按源地址路径确定保存的扩展名。
+func segmentExt(uri string) string {
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	ext := strings.ToLower(path.Ext(uri))
+	if segmentExts[ext] {
+		return ext
+	}
+	return ".ts"
+}
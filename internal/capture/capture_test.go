@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"PinkTide/internal/stream"
+)
+
+func fetchName(_ context.Context, target string) ([]byte, error) {
+	return []byte(target), nil
+}
+
+func TestWriterNamesAndTimesSegments(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, "%03d", fetchName, nil)
+	fetched := time.Date(2026, 1, 1, 0, 0, 10, 0, time.UTC)
+	snap := &stream.Snapshot{
+		Content:    "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:4\n#EXTINF:2,\na.m4s?x=1\n#EXTINF:3,\nb.aac\n#EXTINF:1,\nc.php\n",
+		OriginBase: "https://origin.example.com/live/index.m3u8",
+		FetchedAt:  fetched,
+	}
+	var got []Segment
+	if _, err := w.Update(context.Background(), snap, func(seg Segment) error {
+		got = append(got, seg)
+		return nil
+	}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	want := []Segment{
+		{Index: 0, File: "000.m4s", Size: 41, Start: fetched.Add(-6 * time.Second), Duration: 2 * time.Second},
+		{Index: 1, File: "001.aac", Size: 37, Start: fetched.Add(-4 * time.Second), Duration: 3 * time.Second},
+		{Index: 2, File: "002.ts", Size: 37, Start: fetched.Add(-time.Second), Duration: time.Second},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected segments: %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("segment %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "000.m4s")); err != nil || string(data) != "https://origin.example.com/live/a.m4s?x=1" {
+		t.Fatalf("unexpected segment %q: %v", data, err)
+	}
+
+	master := &stream.Snapshot{Content: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nlow.m3u8\n", OriginBase: snap.OriginBase}
+	if _, err := w.Update(context.Background(), master, func(Segment) error { return nil }); !errors.Is(err, ErrMasterPlaylist) {
+		t.Fatalf("expected master playlist error, got %v", err)
+	}
+}
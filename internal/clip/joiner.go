@@ -0,0 +1,179 @@
+package clip
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	packetSize = 188
+	// clockRate 为 PTS/DTS/PCR 基准时钟频率。
+	clockRate = 90000
+	// startTimestamp 为剪辑首个时间戳，留出余量避免 PCR 早于 DTS 时出现负值。
+	startTimestamp = clockRate
+	// maxDrift 为未标记不连续时允许的时间戳偏差，超过时视为源站重置并重新对齐。
+	maxDrift      = 5 * clockRate
+	timestampMask = 1<<33 - 1
+)
+
+// ErrInvalidSegment 表示切片不是完整的 MPEG-TS 包序列。
+var ErrInvalidSegment = errors.New("invalid mpeg-ts segment")
+
+// Joiner 将多个 MPEG-TS 切片拼接为一个连续文件：时间戳从剪辑起点开始并跨不连续点接续，各 PID 的连续计数保持递增。
+type Joiner struct {
+	continuity map[uint16]byte
+	offset     int64
+	next       int64
+	started    bool
+}
+
+// NewJoiner 创建拼接器。
+func NewJoiner() *Joiner {
+	return &Joiner{continuity: make(map[uint16]byte)}
+}
+
+// Append 修正一个切片后写入 w；discontinuity 表示该切片与上一切片的时间戳不连续，duration 用于推算下一切片的起点。
+func (j *Joiner) Append(w io.Writer, data []byte, duration time.Duration, discontinuity bool) (int, error) {
+	if len(data)%packetSize != 0 {
+		return 0, ErrInvalidSegment
+	}
+	for off := 0; off < len(data); off += packetSize {
+		if data[off] != 0x47 {
+			return 0, ErrInvalidSegment
+		}
+	}
+
+	if base, ok := firstTimestamp(data); ok {
+		switch {
+		case !j.started:
+			j.offset = startTimestamp - base
+			j.started = true
+		case discontinuity || absDiff(base+j.offset, j.next) > maxDrift:
+			j.offset = j.next - base
+		}
+		j.next = base + j.offset + duration.Nanoseconds()*clockRate/int64(time.Second)
+	}
+
+	for off := 0; off < len(data); off += packetSize {
+		j.fixPacket(data[off : off+packetSize])
+	}
+	return w.Write(data)
+}
+
+// fixPacket 重写连续计数、PCR 与 PES 时间戳。
+func (j *Joiner) fixPacket(pkt []byte) {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	control := pkt[3] >> 4 & 0x03
+	payload := 4
+	if control&0x02 != 0 {
+		length := int(pkt[4])
+		if length > 0 && 5+length <= packetSize && pkt[5]&0x10 != 0 && length >= 7 {
+			j.shiftPCR(pkt[6:12])
+		}
+		payload = 5 + length
+	}
+
+	next := j.continuity[pid]
+	if control&0x01 != 0 {
+		pkt[3] = pkt[3]&0xf0 | next
+		j.continuity[pid] = (next + 1) & 0x0f
+	} else {
+		// 无负载的包不递增连续计数。
+		pkt[3] = pkt[3]&0xf0 | (next-1)&0x0f
+	}
+
+	if control&0x01 == 0 || pkt[1]&0x40 == 0 || pid == 0 || payload+14 > packetSize {
+		return
+	}
+	pes := pkt[payload:]
+	if pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || !hasTimestamps(pes[3]) {
+		return
+	}
+	flags := pes[7] >> 6
+	if flags&0x02 != 0 {
+		j.shiftTimestamp(pes[9:14])
+	}
+	if flags == 0x03 && payload+19 <= packetSize {
+		j.shiftTimestamp(pes[14:19])
+	}
+}
+
+func (j *Joiner) shiftTimestamp(b []byte) {
+	ts := (decodeTimestamp(b) + j.offset) & timestampMask
+	prefix := b[0] & 0xf0
+	b[0] = prefix | byte(ts>>29)&0x0e | 0x01
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xfe | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1)&0xfe | 0x01
+}
+
+func (j *Joiner) shiftPCR(b []byte) {
+	base := int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4]>>7)
+	base = (base + j.offset) & timestampMask
+	b[0] = byte(base >> 25)
+	b[1] = byte(base >> 17)
+	b[2] = byte(base >> 9)
+	b[3] = byte(base >> 1)
+	b[4] = byte(base<<7) | b[4]&0x7f
+}
+
+// firstTimestamp 返回切片中最早的 PTS/DTS。
+func firstTimestamp(data []byte) (int64, bool) {
+	var (
+		min   int64
+		found bool
+	)
+	for off := 0; off+packetSize <= len(data); off += packetSize {
+		pkt := data[off : off+packetSize]
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		control := pkt[3] >> 4 & 0x03
+		if control&0x01 == 0 || pkt[1]&0x40 == 0 || pid == 0 {
+			continue
+		}
+		payload := 4
+		if control&0x02 != 0 {
+			payload = 5 + int(pkt[4])
+		}
+		if payload+14 > packetSize {
+			continue
+		}
+		pes := pkt[payload:]
+		if pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || !hasTimestamps(pes[3]) {
+			continue
+		}
+		flags := pes[7] >> 6
+		if flags&0x02 == 0 {
+			continue
+		}
+		ts := decodeTimestamp(pes[9:14])
+		if flags == 0x03 && payload+19 <= packetSize {
+			ts = decodeTimestamp(pes[14:19])
+		}
+		if !found || ts < min {
+			min, found = ts, true
+		}
+	}
+	return min, found
+}
+
+// hasTimestamps 判断 PES 流类型是否带可选头部，填充流与私有流 2 等没有时间戳。
+func hasTimestamps(streamID byte) bool {
+	switch streamID {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		return false
+	}
+	return true
+}
+
+func decodeTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func absDiff(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
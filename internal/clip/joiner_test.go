@@ -0,0 +1,110 @@
+package clip
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// testSegment 生成 PAT、带 PCR 的视频 PES 首包、仅含自适应字段的包与音频 PES 首包。
+func testSegment(cc byte, dts int64) []byte {
+	var out []byte
+	pat := make([]byte, packetSize)
+	pat[0], pat[1], pat[2], pat[3] = 0x47, 0x40, 0x00, 0x10|cc
+	out = append(out, pat...)
+
+	video := make([]byte, packetSize)
+	video[0], video[1], video[2], video[3] = 0x47, 0x41, 0x00, 0x30|cc
+	video[4] = 7
+	video[5] = 0x50
+	copy(video[6:12], encodePCRBase(dts-900))
+	pes := video[12:]
+	copy(pes, []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0xc0, 10})
+	copy(pes[9:], encode(0x3, dts+3000))
+	copy(pes[14:], encode(0x1, dts))
+	out = append(out, video...)
+
+	stuffing := make([]byte, packetSize)
+	stuffing[0], stuffing[1], stuffing[2], stuffing[3] = 0x47, 0x01, 0x00, 0x20|cc
+	stuffing[4] = 183
+	out = append(out, stuffing...)
+
+	audio := make([]byte, packetSize)
+	audio[0], audio[1], audio[2], audio[3] = 0x47, 0x41, 0x01, 0x10|cc
+	copy(audio[4:], []byte{0, 0, 1, 0xc0, 0, 0, 0x80, 0x80, 5})
+	copy(audio[13:], encode(0x2, dts-1800))
+	return append(out, audio...)
+}
+
+func encode(prefix byte, ts int64) []byte {
+	b := make([]byte, 5)
+	b[0] = prefix << 4
+	j := &Joiner{offset: ts}
+	j.shiftTimestamp(b)
+	return b
+}
+
+func encodePCRBase(base int64) []byte {
+	b := make([]byte, 6)
+	j := &Joiner{offset: base}
+	j.shiftPCR(b)
+	return b
+}
+
+func TestJoinerRebasesTimestamps(t *testing.T) {
+	var out bytes.Buffer
+	j := NewJoiner()
+	segments := []struct {
+		data          []byte
+		discontinuity bool
+	}{
+		{testSegment(5, 900000), false},
+		{testSegment(9, 1080000), false},
+		{testSegment(0, 4500), true},
+	}
+	for _, seg := range segments {
+		if _, err := j.Append(&out, seg.data, 2*time.Second, seg.discontinuity); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	data := out.Bytes()
+	wantDTS := []int64{startTimestamp + 1800, startTimestamp + 1800 + 180000, startTimestamp + 1800 + 360000}
+	for i, want := range wantDTS {
+		seg := data[i*4*packetSize:]
+		video, audio := seg[packetSize:], seg[3*packetSize:]
+		if got := decodeTimestamp(video[12+14:]); got != want {
+			t.Fatalf("segment %d dts = %d, want %d", i, got, want)
+		}
+		if got := decodeTimestamp(video[12+9:]); got != want+3000 {
+			t.Fatalf("segment %d pts = %d, want %d", i, got, want+3000)
+		}
+		if got := decodeTimestamp(audio[13:]); got != want-1800 {
+			t.Fatalf("segment %d audio pts = %d, want %d", i, got, want-1800)
+		}
+		pcr := int64(video[6])<<25 | int64(video[7])<<17 | int64(video[8])<<9 | int64(video[9])<<1 | int64(video[10]>>7)
+		if pcr != want-900 {
+			t.Fatalf("segment %d pcr = %d, want %d", i, pcr, want-900)
+		}
+	}
+
+	// 各 PID 的连续计数跨切片递增，仅含自适应字段的包沿用上一计数。
+	counters := map[uint16][]byte{}
+	for off := 0; off < len(data); off += packetSize {
+		pid := uint16(data[off+1]&0x1f)<<8 | uint16(data[off+2])
+		counters[pid] = append(counters[pid], data[off+3]&0x0f)
+	}
+	if got := counters[0]; !bytes.Equal(got, []byte{0, 1, 2}) {
+		t.Fatalf("unexpected PAT counters %v", got)
+	}
+	if got := counters[0x100]; !bytes.Equal(got, []byte{0, 0, 1, 1, 2, 2}) {
+		t.Fatalf("unexpected video counters %v", got)
+	}
+	if got := counters[0x101]; !bytes.Equal(got, []byte{0, 1, 2}) {
+		t.Fatalf("unexpected audio counters %v", got)
+	}
+
+	if _, err := j.Append(&out, make([]byte, 100), time.Second, false); err != ErrInvalidSegment {
+		t.Fatalf("expected invalid segment error, got %v", err)
+	}
+}
@@ -3,26 +3,56 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"PinkTide/internal/bili"
 )
 
 // Config 统一承载运行期配置，来源于环境变量并完成归一化。
 type Config struct {
-	ListenAddr       string
-	CDNPublicURL     string
-	BiliRoomID       string
-	LogLevel         string
-	TLSMode          string
-	TLSCertFile      string
-	TLSKeyFile       string
-	TLSCertDir       string
-	HTTPRedirectAddr string
-	RefreshInterval  time.Duration
-	RequestTimeout   time.Duration
-	ReadTimeout      time.Duration
-	WriteTimeout     time.Duration
-	IdleTimeout      time.Duration
+	ListenAddr          string
+	CDNPublicURL        string
+	BiliRoomID          string
+	LogLevel            string
+	TLSMode             string
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSCertDir          string
+	HTTPRedirectAddr    string
+	RefreshInterval     time.Duration
+	RequestTimeout      time.Duration
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	SegmentCacheSize    int64
+	SegmentCacheTTL     time.Duration
+	SigningKeys         string
+	SigningTTL          time.Duration
+	OriginSchemes       []string
+	OriginHosts         []string
+	OriginBlockPrivate  bool
+	PollerIdleTimeout   time.Duration
+	ResolverIdleTimeout time.Duration
+	ResolverMaxRooms    int
+	StatusLiveTTL       time.Duration
+	StatusOfflineTTL    time.Duration
+	DefaultQn           int
+	DefaultProtocol     string
+	DefaultFormat       string
+	DefaultCodec        string
+	RecordRooms         []string
+	RecordDir           string
+	RecordInterval      time.Duration
+	DVRWindow           time.Duration
+	DVRRetention        time.Duration
+	DVRDir              string
+	MetricsEnabled      bool
+	TraceExporter       string
+	TraceOTLPEndpoint   string
+	TraceFile           string
+	TraceSampleRatio    float64
 }
 
 // Load 加载并校验配置，缺失必填项或格式错误时返回错误。
@@ -31,20 +61,45 @@ func Load() (Config, error) {
 		return Config{}, err
 	}
 	cfg := Config{
-		ListenAddr:       getEnv("PT_LISTEN_ADDR", ":8080"),
-		CDNPublicURL:     getEnv("PT_CDN_PUBLIC_URL", ""),
-		BiliRoomID:       getEnv("PT_BILI_ROOM_ID", ""),
-		LogLevel:         getEnv("PT_LOG_LEVEL", "info"),
-		TLSMode:          getEnv("PT_TLS_MODE", "https"),
-		TLSCertFile:      getEnv("PT_TLS_CERT_FILE", ""),
-		TLSKeyFile:       getEnv("PT_TLS_KEY_FILE", ""),
-		TLSCertDir:       getEnv("PT_TLS_CERT_DIR", "certs"),
-		HTTPRedirectAddr: getEnv("PT_HTTP_REDIRECT_ADDR", ":8081"),
-		RefreshInterval:  10 * time.Minute,
-		RequestTimeout:   5 * time.Second,
-		ReadTimeout:      10 * time.Second,
-		WriteTimeout:     10 * time.Second,
-		IdleTimeout:      60 * time.Second,
+		ListenAddr:          getEnv("PT_LISTEN_ADDR", ":8080"),
+		CDNPublicURL:        getEnv("PT_CDN_PUBLIC_URL", ""),
+		BiliRoomID:          getEnv("PT_BILI_ROOM_ID", ""),
+		LogLevel:            getEnv("PT_LOG_LEVEL", "info"),
+		TLSMode:             getEnv("PT_TLS_MODE", "https"),
+		TLSCertFile:         getEnv("PT_TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnv("PT_TLS_KEY_FILE", ""),
+		TLSCertDir:          getEnv("PT_TLS_CERT_DIR", "certs"),
+		HTTPRedirectAddr:    getEnv("PT_HTTP_REDIRECT_ADDR", ":8081"),
+		RefreshInterval:     10 * time.Minute,
+		RequestTimeout:      5 * time.Second,
+		ReadTimeout:         10 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		IdleTimeout:         60 * time.Second,
+		SegmentCacheSize:    256 << 20,
+		SegmentCacheTTL:     10 * time.Minute,
+		SigningKeys:         getEnv("PT_SIGNING_KEYS", ""),
+		SigningTTL:          time.Hour,
+		OriginSchemes:       splitList(getEnv("PT_ORIGIN_ALLOW_SCHEMES", "https,http")),
+		OriginHosts:         splitList(getEnv("PT_ORIGIN_ALLOW_HOSTS", "*.bilivideo.com,*.bilivideo.cn")),
+		OriginBlockPrivate:  true,
+		PollerIdleTimeout:   30 * time.Second,
+		ResolverIdleTimeout: 5 * time.Minute,
+		ResolverMaxRooms:    100,
+		StatusLiveTTL:       30 * time.Second,
+		StatusOfflineTTL:    10 * time.Second,
+		DefaultQn:           10000,
+		DefaultProtocol:     getEnv("PT_DEFAULT_PROTOCOL", "http_hls"),
+		DefaultFormat:       getEnv("PT_DEFAULT_FORMAT", "ts"),
+		DefaultCodec:        getEnv("PT_DEFAULT_CODEC", "avc"),
+		RecordRooms:         splitList(getEnv("PT_RECORD_ROOMS", "")),
+		RecordDir:           getEnv("PT_RECORD_DIR", "recordings"),
+		RecordInterval:      30 * time.Second,
+		DVRDir:              getEnv("PT_DVR_DIR", "dvr"),
+		MetricsEnabled:      false,
+		TraceExporter:       getEnv("PT_TRACE_EXPORTER", ""),
+		TraceOTLPEndpoint:   getEnv("PT_TRACE_OTLP_ENDPOINT", "http://127.0.0.1:4318/v1/traces"),
+		TraceFile:           getEnv("PT_TRACE_FILE", "traces.jsonl"),
+		TraceSampleRatio:    1,
 	}
 
 	if v, ok := os.LookupEnv("PT_REFRESH_INTERVAL"); ok {
@@ -87,6 +142,151 @@ func Load() (Config, error) {
 		cfg.IdleTimeout = d
 	}
 
+	if v, ok := os.LookupEnv("PT_SEGMENT_CACHE_SIZE"); ok {
+		n, err := parseByteSize(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_SEGMENT_CACHE_SIZE failed: %w", err)
+		}
+		cfg.SegmentCacheSize = n
+	}
+
+	if v, ok := os.LookupEnv("PT_SEGMENT_CACHE_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_SEGMENT_CACHE_TTL failed: %w", err)
+		}
+		cfg.SegmentCacheTTL = d
+	}
+
+	if v, ok := os.LookupEnv("PT_SIGNING_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_SIGNING_TTL failed: %w", err)
+		}
+		cfg.SigningTTL = d
+	}
+
+	if v, ok := os.LookupEnv("PT_ORIGIN_BLOCK_PRIVATE"); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_ORIGIN_BLOCK_PRIVATE failed: %w", err)
+		}
+		cfg.OriginBlockPrivate = b
+	}
+
+	if v, ok := os.LookupEnv("PT_POLLER_IDLE_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_POLLER_IDLE_TIMEOUT failed: %w", err)
+		}
+		cfg.PollerIdleTimeout = d
+	}
+
+	if v, ok := os.LookupEnv("PT_RESOLVER_IDLE_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_RESOLVER_IDLE_TIMEOUT failed: %w", err)
+		}
+		cfg.ResolverIdleTimeout = d
+	}
+
+	if v, ok := os.LookupEnv("PT_RESOLVER_MAX_ROOMS"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_RESOLVER_MAX_ROOMS failed: %w", err)
+		}
+		cfg.ResolverMaxRooms = n
+	}
+
+	if v, ok := os.LookupEnv("PT_STATUS_LIVE_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_STATUS_LIVE_TTL failed: %w", err)
+		}
+		cfg.StatusLiveTTL = d
+	}
+
+	if v, ok := os.LookupEnv("PT_STATUS_OFFLINE_TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_STATUS_OFFLINE_TTL failed: %w", err)
+		}
+		cfg.StatusOfflineTTL = d
+	}
+
+	if v, ok := os.LookupEnv("PT_DEFAULT_QN"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_DEFAULT_QN failed: %w", err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("PT_DEFAULT_QN must be positive: %d", n)
+		}
+		cfg.DefaultQn = n
+	}
+
+	if v, ok := os.LookupEnv("PT_RECORD_INTERVAL"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_RECORD_INTERVAL failed: %w", err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("PT_RECORD_INTERVAL must be positive: %s", d)
+		}
+		cfg.RecordInterval = d
+	}
+
+	if v, ok := os.LookupEnv("PT_DVR_WINDOW"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_DVR_WINDOW failed: %w", err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("PT_DVR_WINDOW must not be negative: %s", d)
+		}
+		cfg.DVRWindow = d
+	}
+
+	cfg.DVRRetention = cfg.DVRWindow
+	if v, ok := os.LookupEnv("PT_DVR_RETENTION"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_DVR_RETENTION failed: %w", err)
+		}
+		if d < cfg.DVRWindow {
+			return Config{}, fmt.Errorf("PT_DVR_RETENTION must not be shorter than PT_DVR_WINDOW: %s", d)
+		}
+		cfg.DVRRetention = d
+	}
+
+	if v, ok := os.LookupEnv("PT_METRICS_ENABLED"); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_METRICS_ENABLED failed: %w", err)
+		}
+		cfg.MetricsEnabled = b
+	}
+
+	if v, ok := os.LookupEnv("PT_TRACE_SAMPLE_RATIO"); ok {
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse PT_TRACE_SAMPLE_RATIO failed: %w", err)
+		}
+		if ratio < 0 || ratio > 1 {
+			return Config{}, fmt.Errorf("PT_TRACE_SAMPLE_RATIO out of range [0, 1]: %v", ratio)
+		}
+		cfg.TraceSampleRatio = ratio
+	}
+
+	cfg.TraceExporter = strings.ToLower(strings.TrimSpace(cfg.TraceExporter))
+	switch cfg.TraceExporter {
+	case "", "none", "otlp", "file":
+	default:
+		return Config{}, fmt.Errorf("invalid PT_TRACE_EXPORTER: %s", cfg.TraceExporter)
+	}
+	cfg.TraceOTLPEndpoint = strings.TrimSpace(cfg.TraceOTLPEndpoint)
+	cfg.TraceFile = strings.TrimSpace(cfg.TraceFile)
+
 	cfg.CDNPublicURL = strings.TrimSpace(cfg.CDNPublicURL)
 	cfg.CDNPublicURL = strings.TrimRight(cfg.CDNPublicURL, "/")
 	cfg.BiliRoomID = strings.TrimSpace(cfg.BiliRoomID)
@@ -94,6 +294,16 @@ func Load() (Config, error) {
 	cfg.TLSKeyFile = strings.TrimSpace(cfg.TLSKeyFile)
 	cfg.TLSCertDir = strings.TrimSpace(cfg.TLSCertDir)
 	cfg.HTTPRedirectAddr = strings.TrimSpace(cfg.HTTPRedirectAddr)
+	cfg.SigningKeys = strings.TrimSpace(cfg.SigningKeys)
+	cfg.RecordDir = strings.TrimSpace(cfg.RecordDir)
+	cfg.DVRDir = strings.TrimSpace(cfg.DVRDir)
+	cfg.DefaultProtocol = strings.ToLower(strings.TrimSpace(cfg.DefaultProtocol))
+	cfg.DefaultFormat = strings.ToLower(strings.TrimSpace(cfg.DefaultFormat))
+	cfg.DefaultCodec = strings.ToLower(strings.TrimSpace(cfg.DefaultCodec))
+	defaults := bili.PlayOptions{Protocol: cfg.DefaultProtocol, Format: cfg.DefaultFormat, Codec: cfg.DefaultCodec}
+	if err := defaults.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid default play options: %w", err)
+	}
 	cfg.TLSMode = strings.ToLower(strings.TrimSpace(cfg.TLSMode))
 	if cfg.TLSMode == "" {
 		cfg.TLSMode = "https"
@@ -147,6 +357,44 @@ func loadDotEnv(path string) error {
 	return nil
 }
 
+// parseByteSize 解析字节数，支持 KB/MB/GB 后缀（按 1024 进位），0 表示关闭。
+func parseByteSize(raw string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(raw))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size: %s", raw)
+	}
+	return n * multiplier, nil
+}
+
+// splitList 按逗号拆分并去除空白项。
+func splitList(raw string) []string {
+	var items []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			items = append(items, part)
+		}
+	}
+	return items
+}
+
 // getEnv 读取环境变量，未设置时回退默认值。
 func getEnv(key, fallback string) string {
 	if v, ok := os.LookupEnv(key); ok {
@@ -0,0 +1,87 @@
+package dvr
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"PinkTide/internal/capture"
+)
+
+// clipTolerance 为判断时间范围是否完整覆盖时容忍的误差，切片起始时刻可能由拉取时间估算。
+const clipTolerance = time.Second
+
+var (
+	// ErrNotBuffered 表示房间没有正在采集的时移窗口。
+	ErrNotBuffered = errors.New("room not buffered")
+	// ErrRangeUnavailable 表示请求的时间范围内没有可用切片。
+	ErrRangeUnavailable = errors.New("range not available")
+)
+
+// Clip 为按墙上时间选取的一段时移切片，Start/End 为实际覆盖范围，Complete 表示完整覆盖请求范围。
+type Clip struct {
+	Segments []ClipSegment
+	Start    time.Time
+	End      time.Time
+	Complete bool
+}
+
+// ClipSegment 为剪辑中的一个切片文件，Size 为采集时写入的字节数。
+type ClipSegment struct {
+	Path          string
+	Size          int64
+	Start         time.Time
+	Duration      time.Duration
+	Discontinuity bool
+	Init          bool
+}
+
+// Clip 选取与 [from, to) 重叠的切片；范围内没有切片时返回 ErrRangeUnavailable，Start/End 为当前窗口范围。
+// 返回的文件可能在读取前滑出窗口被删除，调用方需处理读取失败。
+func (s *Store) Clip(key string, from, to time.Time) (Clip, error) {
+	s.mu.Lock()
+	c, ok := s.collectors[collectorID(key)]
+	s.mu.Unlock()
+	if !ok || c.finished() {
+		return Clip{}, ErrNotBuffered
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) == 0 {
+		return Clip{}, ErrNotBuffered
+	}
+	var clip Clip
+	complete := true
+	var previous *capture.Segment
+	for i := range c.entries {
+		e := &c.entries[i]
+		end := e.Start.Add(e.Duration)
+		if !end.After(from) || !e.Start.Before(to) {
+			continue
+		}
+		if previous != nil && e.Start.Sub(previous.Start.Add(previous.Duration)) > clipTolerance {
+			complete = false
+		}
+		clip.Segments = append(clip.Segments, ClipSegment{
+			Path:          filepath.Join(c.dir, e.File),
+			Size:          e.Size,
+			Start:         e.Start,
+			Duration:      e.Duration,
+			Discontinuity: previous != nil && e.Discontinuity,
+			Init:          e.MapFile != "",
+		})
+		previous = e
+	}
+	if len(clip.Segments) == 0 {
+		last := c.entries[len(c.entries)-1]
+		return Clip{Start: c.entries[0].Start, End: last.Start.Add(last.Duration)}, ErrRangeUnavailable
+	}
+	first, last := clip.Segments[0], clip.Segments[len(clip.Segments)-1]
+	clip.Start, clip.End = first.Start, last.Start.Add(last.Duration)
+	if first.Start.Sub(from) > clipTolerance || to.Sub(clip.End) > clipTolerance {
+		complete = false
+	}
+	clip.Complete = complete
+	return clip, nil
+}
@@ -0,0 +1,196 @@
+package dvr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"PinkTide/internal/capture"
+	"PinkTide/internal/stream"
+)
+
+// collector 持续把一个房间的新切片写入本地目录，并淘汰超出时移窗口的旧切片。
+type collector struct {
+	id     string
+	key    string
+	gen    string
+	dir    string
+	window time.Duration
+	logger *slog.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+	// writer 只由采集协程使用。
+	writer *capture.Writer
+
+	mu              sync.Mutex
+	entries         []capture.Segment
+	duration        time.Duration
+	discontinuities int64
+	snapshot        *stream.Snapshot
+	err             error
+	accessed        time.Time
+	updated         chan struct{}
+}
+
+// run 轮询直播播放列表并采集新切片，退出时删除采集目录。
+func (c *collector) run(ctx context.Context, playlist Playlist) {
+	defer close(c.done)
+	defer func() {
+		_ = os.RemoveAll(c.dir)
+		// 同一房间的新采集器可能已在父目录下创建新的代目录，父目录只在为空时删除。
+		_ = os.Remove(filepath.Dir(c.dir))
+	}()
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		c.fail(err)
+		return
+	}
+	if c.logger != nil {
+		c.logger.Debug("dvr collector started", "key", c.key)
+		defer c.logger.Debug("dvr collector stopped", "key", c.key)
+	}
+
+	var last *stream.Snapshot
+	ticker := time.NewTicker(capture.PollInterval)
+	defer ticker.Stop()
+	for {
+		snap, err := playlist(ctx)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			c.fail(err)
+		case snap != last:
+			last = snap
+			if err := c.update(ctx, snap); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if c.logger != nil {
+					c.logger.Error("dvr collector stopped on error", "key", c.key, "error", err)
+				}
+				c.fail(err)
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update 采集播放列表中尚未写入的切片，每写入一个切片更新一次时移窗口。
+func (c *collector) update(ctx context.Context, snap *stream.Snapshot) error {
+	_, err := c.writer.Update(ctx, snap, func(seg capture.Segment) error {
+		c.append(seg)
+		return nil
+	})
+	return err
+}
+
+// append 追加切片、淘汰超出窗口的旧切片并重新生成播放列表。
+func (c *collector) append(e capture.Segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, e)
+	c.duration += e.Duration
+
+	// 滑出窗口的不连续点计入 EXT-X-DISCONTINUITY-SEQUENCE，窗口首个切片不再标注。
+	var removed []capture.Segment
+	for len(c.entries) > 1 && c.duration-c.entries[0].Duration >= c.window {
+		removed = append(removed, c.entries[0])
+		c.duration -= c.entries[0].Duration
+		c.entries = c.entries[1:]
+		if c.entries[0].Discontinuity {
+			c.discontinuities++
+			c.entries[0].Discontinuity = false
+		}
+	}
+	for _, old := range removed {
+		_ = os.Remove(filepath.Join(c.dir, old.File))
+		if old.MapFile != "" && old.MapFile != c.entries[0].MapFile {
+			_ = os.Remove(filepath.Join(c.dir, old.MapFile))
+		}
+	}
+
+	c.snapshot = &stream.Snapshot{
+		Content:        renderPlaylist(c.entries, c.discontinuities),
+		OriginBase:     fmt.Sprintf("%s://%s/%s/index.m3u8", Scheme, c.id, c.gen),
+		FetchedAt:      time.Now(),
+		TargetDuration: c.entries[len(c.entries)-1].Duration,
+	}
+	c.err = nil
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+// fail 记录最近一次采集错误并唤醒等待者。
+func (c *collector) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+// holds 判断文件仍在窗口内。
+func (c *collector) holds(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.File == name || e.MapFile == name {
+			return true
+		}
+	}
+	return false
+}
+
+// touch 记录最近一次访问时间。
+func (c *collector) touch() {
+	c.mu.Lock()
+	c.accessed = time.Now()
+	c.mu.Unlock()
+}
+
+// lastAccess 返回最近一次访问时间。
+func (c *collector) lastAccess() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.accessed
+}
+
+// finished 判断采集协程是否已退出。
+func (c *collector) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *collector) warn(msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Warn("dvr "+msg, append([]any{"key", c.key}, args...)...)
+	}
+}
+
+// renderPlaylist 生成时移窗口的滑动播放列表，切片地址相对于播放列表，
+// 首个切片与不连续点后标注 EXT-X-PROGRAM-DATE-TIME 便于按时间定位。
+func renderPlaylist(entries []capture.Segment, discontinuities int64) string {
+	pl := capture.Playlist(entries, "")
+	pl.SetDiscontinuitySequence(discontinuities)
+	for i, seg := range pl.Segments {
+		if i == 0 || seg.Discontinuity {
+			seg.SetProgramDateTime(entries[i].Start.UTC())
+		}
+	}
+	return pl.String()
+}
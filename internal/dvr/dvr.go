@@ -0,0 +1,210 @@
+package dvr
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"PinkTide/internal/capture"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/trace"
+)
+
+// Scheme 为时移切片的内部地址协议，/seg 据此从本地存储读取而非回源。
+const Scheme = "dvr"
+
+// firstSegmentTimeout 为等待首个切片写入的最长时间。
+const firstSegmentTimeout = 10 * time.Second
+
+// ErrNotReady 表示时移窗口尚未采集到切片。
+var ErrNotReady = errors.New("dvr not ready")
+
+// Playlist 返回房间最新的直播播放列表。
+type Playlist func(ctx context.Context) (*stream.Snapshot, error)
+
+// Fetch 下载切片或初始化段。
+type Fetch = capture.Fetch
+
+// Store 为活跃房间采集切片到本地目录，维护指定时长的时移窗口。
+type Store struct {
+	dir        string
+	window     time.Duration
+	retention  time.Duration
+	fetch      Fetch
+	logger     *slog.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	collectors map[string]*collector
+}
+
+// New 创建时移存储并清理上次运行遗留的采集目录，采集器超过 retention 无访问时停止并删除数据。
+func New(dir string, window, retention time.Duration, fetch Fetch, logger *slog.Logger) *Store {
+	ctx, cancel := context.WithCancel(trace.Background(context.Background()))
+	s := &Store{
+		dir:        dir,
+		window:     window,
+		retention:  retention,
+		fetch:      fetch,
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		collectors: make(map[string]*collector),
+	}
+	s.removeStale()
+	go s.janitor()
+	return s
+}
+
+// Track 确保 key 对应房间正在采集并刷新访问时间。
+func (s *Store) Track(key string, playlist Playlist) {
+	s.acquire(key, playlist)
+}
+
+// Snapshot 返回 key 对应房间的时移播放列表，首次访问时启动采集并等待首个切片。
+func (s *Store) Snapshot(ctx context.Context, key string, playlist Playlist) (*stream.Snapshot, error) {
+	c := s.acquire(key, playlist)
+	timer := time.NewTimer(firstSegmentTimeout)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		snap, err, updated := c.snapshot, c.err, c.updated
+		c.mu.Unlock()
+		if snap != nil {
+			return snap, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil, ErrNotReady
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Segment 按内部地址读取切片或初始化段，采集已停止或切片滑出窗口时返回 false。
+func (s *Store) Segment(target string) ([]byte, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != Scheme {
+		return nil, false
+	}
+	gen, name, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if !ok {
+		return nil, false
+	}
+	s.mu.Lock()
+	c, ok := s.collectors[u.Host]
+	s.mu.Unlock()
+	if !ok || c.gen != gen || !c.holds(name) {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Close 停止全部采集并删除采集数据。
+func (s *Store) Close() {
+	s.cancel()
+}
+
+// acquire 获取或创建采集器并刷新访问时间，已结束的采集器会被替换。
+func (s *Store) acquire(key string, playlist Playlist) *collector {
+	id := collectorID(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collectors[id]
+	if ok && c.finished() {
+		ok = false
+	}
+	if !ok {
+		ctx, cancel := context.WithCancel(s.ctx)
+		gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+		c = &collector{
+			id:       id,
+			key:      key,
+			gen:      gen,
+			dir:      filepath.Join(s.dir, id, gen),
+			window:   s.window,
+			logger:   s.logger,
+			cancel:   cancel,
+			done:     make(chan struct{}),
+			updated:  make(chan struct{}),
+			accessed: time.Now(),
+		}
+		c.writer = capture.NewWriter(c.dir, "%d", s.fetch, c.warn)
+		s.collectors[id] = c
+		go c.run(ctx, playlist)
+	}
+	c.touch()
+	return c
+}
+
+// janitor 定期停止空闲采集器。
+func (s *Store) janitor() {
+	interval := s.retention / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.evictIdle(time.Now())
+		}
+	}
+}
+
+// evictIdle 停止无访问超过保留时长或已结束的采集器。
+func (s *Store) evictIdle(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.collectors {
+		if !c.finished() && now.Sub(c.lastAccess()) < s.retention {
+			continue
+		}
+		c.cancel()
+		delete(s.collectors, id)
+	}
+}
+
+// removeStale 删除上次运行遗留的采集目录，只处理符合采集器标识格式的子目录。
+func (s *Store) removeStale() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) != 16 {
+			continue
+		}
+		if _, err := hex.DecodeString(e.Name()); err != nil {
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(s.dir, e.Name()))
+	}
+}
+
+// collectorID 将采集键转换为可用作 URL 主机名与目录名的标识。
+func collectorID(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,193 @@
+package dvr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"PinkTide/internal/capture"
+	"PinkTide/internal/stream"
+)
+
+// testEpoch 为测试播放列表中序号 0 切片的起始时刻。
+var testEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// livePlaylist 生成从 sequence 开始、含 count 个 2 秒切片的播放列表，拉取时刻为最后一个切片结束时。
+func livePlaylist(sequence, count int) *stream.Snapshot {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, "#EXTINF:2.000,\nseg%d.ts\n", sequence+i)
+	}
+	return &stream.Snapshot{
+		Content:    b.String(),
+		OriginBase: "https://origin.example.com/live/index.m3u8",
+		FetchedAt:  testEpoch.Add(time.Duration(sequence+count) * 2 * time.Second),
+	}
+}
+
+func fetchName(_ context.Context, target string) ([]byte, error) {
+	return []byte(target), nil
+}
+
+func newTestCollector(t *testing.T, window time.Duration) *collector {
+	t.Helper()
+	dir := t.TempDir()
+	c := &collector{id: "c", gen: "g", dir: dir, window: window, updated: make(chan struct{})}
+	c.writer = capture.NewWriter(dir, "%d", fetchName, nil)
+	return c
+}
+
+func TestCollectorSlidesWindow(t *testing.T) {
+	c := newTestCollector(t, 6*time.Second)
+	ctx := context.Background()
+	for _, snap := range []*stream.Snapshot{livePlaylist(10, 3), livePlaylist(12, 3), livePlaylist(16, 3)} {
+		if err := c.update(ctx, snap); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	// 10-14 与 16-18 共 8 个切片，15 缺失；窗口 6 秒保留最后 3 个。
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:5\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:32.000Z\n#EXTINF:2.000,\n5.ts\n#EXTINF:2.000,\n6.ts\n#EXTINF:2.000,\n7.ts\n"
+	if got := c.snapshot.Content; got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "4.ts")); !os.IsNotExist(err) {
+		t.Fatalf("expected evicted segment removed, got %v", err)
+	}
+	if !c.holds("7.ts") || c.holds("4.ts") {
+		t.Fatalf("unexpected window membership")
+	}
+	if c.snapshot.OriginBase != "dvr://c/g/index.m3u8" {
+		t.Fatalf("unexpected origin base: %s", c.snapshot.OriginBase)
+	}
+}
+
+func TestCollectorMarksGapInWindow(t *testing.T) {
+	c := newTestCollector(t, time.Hour)
+	ctx := context.Background()
+	for _, snap := range []*stream.Snapshot{livePlaylist(10, 2), livePlaylist(20, 2), livePlaylist(0, 1)} {
+		if err := c.update(ctx, snap); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	content := c.snapshot.Content
+	if strings.Count(content, "#EXT-X-DISCONTINUITY\n") != 2 || strings.Contains(content, "DISCONTINUITY-SEQUENCE") {
+		t.Fatalf("unexpected playlist:\n%s", content)
+	}
+	if !strings.Contains(content, "1.ts\n#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:40.000Z\n#EXTINF:2.000,\n2.ts\n") {
+		t.Fatalf("expected discontinuity before gap:\n%s", content)
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, "4.ts"))
+	if err != nil || string(data) != "https://origin.example.com/live/seg0.ts" {
+		t.Fatalf("unexpected segment %q: %v", data, err)
+	}
+}
+
+func TestStoreServesWindow(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "0123456789abcdef")
+	keep := filepath.Join(dir, "keep")
+	for _, d := range []string{stale, keep} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+	}
+
+	store := New(dir, time.Hour, time.Minute, fetchName, nil)
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected stale collector dir removed")
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatalf("expected unrelated dir kept: %v", err)
+	}
+
+	snap := livePlaylist(1, 2)
+	playlist := func(context.Context) (*stream.Snapshot, error) { return snap, nil }
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	got, err := store.Snapshot(ctx, "room", playlist)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	base := strings.TrimSuffix(got.OriginBase, "index.m3u8")
+	data, ok := store.Segment(base + "0.ts")
+	if !ok || string(data) != "https://origin.example.com/live/seg1.ts" {
+		t.Fatalf("unexpected segment %q", data)
+	}
+	if _, ok := store.Segment(base + "9.ts"); ok {
+		t.Fatalf("expected unknown segment missing")
+	}
+	if _, ok := store.Segment(fmt.Sprintf("%s://%s/other/1.ts", Scheme, collectorID("room"))); ok {
+		t.Fatalf("expected other generation missing")
+	}
+
+	store.Close()
+	collectorDir := filepath.Join(dir, collectorID("room"))
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := os.Stat(collectorDir); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected collector dir removed on close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreKeepsCollectorForRetention(t *testing.T) {
+	c := newTestCollector(t, time.Hour)
+	c.done = make(chan struct{})
+	cancelled := false
+	c.cancel = func() { cancelled = true }
+	c.touch()
+	store := &Store{retention: time.Hour, collectors: map[string]*collector{collectorID("room"): c}}
+
+	// 观众离开时长短于保留时长时窗口内的数据仍可回看。
+	store.evictIdle(time.Now().Add(30 * time.Minute))
+	if cancelled || len(store.collectors) != 1 {
+		t.Fatalf("expected collector kept within retention")
+	}
+	store.evictIdle(time.Now().Add(2 * time.Hour))
+	if !cancelled || len(store.collectors) != 0 {
+		t.Fatalf("expected collector evicted after retention")
+	}
+}
+
+func TestStoreClipSelectsRange(t *testing.T) {
+	c := newTestCollector(t, time.Hour)
+	c.done = make(chan struct{})
+	ctx := context.Background()
+	// 序号 10-13 连续，14-15 缺失，16-17 在窗口内。
+	for _, snap := range []*stream.Snapshot{livePlaylist(10, 4), livePlaylist(16, 2)} {
+		if err := c.update(ctx, snap); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	store := &Store{collectors: map[string]*collector{collectorID("room"): c}}
+	at := func(seconds int) time.Time { return testEpoch.Add(time.Duration(seconds) * time.Second) }
+
+	clip, err := store.Clip("room", at(22), at(26))
+	if err != nil || !clip.Complete || len(clip.Segments) != 2 {
+		t.Fatalf("unexpected clip: %+v, %v", clip, err)
+	}
+	if !clip.Start.Equal(at(22)) || !clip.End.Equal(at(26)) || filepath.Base(clip.Segments[0].Path) != "1.ts" {
+		t.Fatalf("unexpected clip range: %+v", clip)
+	}
+
+	clip, err = store.Clip("room", at(24), at(34))
+	if err != nil || clip.Complete || len(clip.Segments) != 3 || !clip.Segments[2].Discontinuity {
+		t.Fatalf("expected partial clip across gap: %+v, %v", clip, err)
+	}
+	if clip, err = store.Clip("room", at(0), at(10)); err != ErrRangeUnavailable || !clip.Start.Equal(at(20)) || !clip.End.Equal(at(36)) {
+		t.Fatalf("expected unavailable range with window bounds: %+v, %v", clip, err)
+	}
+	if _, err := store.Clip("other", at(20), at(22)); err != ErrNotBuffered {
+		t.Fatalf("expected not buffered, got %v", err)
+	}
+}
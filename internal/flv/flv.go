@@ -0,0 +1,103 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FLV 标签类型。
+const (
+	TagAudio  = 8
+	TagVideo  = 9
+	TagScript = 18
+)
+
+const (
+	// headerSize 为文件头与首个 PreviousTagSize 的总长度。
+	headerSize    = 9 + 4
+	tagHeaderSize = 11
+)
+
+// ErrInvalidHeader 表示上游数据不是 FLV 流。
+var ErrInvalidHeader = errors.New("invalid flv header")
+
+// Tag 为一个 FLV 标签，Data 不含标签头与 PreviousTagSize。
+type Tag struct {
+	Type      byte
+	Timestamp uint32
+	Data      []byte
+}
+
+// ReadHeader 读取文件头与首个 PreviousTagSize 并原样返回。
+func ReadHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:3]) != "FLV" {
+		return nil, ErrInvalidHeader
+	}
+	if offset := binary.BigEndian.Uint32(header[5:9]); offset != 9 {
+		return nil, fmt.Errorf("%w: data offset %d", ErrInvalidHeader, offset)
+	}
+	return header, nil
+}
+
+// ReadTag 读取一个标签及其后的 PreviousTagSize。
+func ReadTag(r io.Reader) (Tag, error) {
+	var head [tagHeaderSize]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return Tag{}, err
+	}
+	size := uint32(head[1])<<16 | uint32(head[2])<<8 | uint32(head[3])
+	timestamp := uint32(head[7])<<24 | uint32(head[4])<<16 | uint32(head[5])<<8 | uint32(head[6])
+	data := make([]byte, size+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Tag{}, err
+	}
+	return Tag{Type: head[0] & 0x1f, Timestamp: timestamp, Data: data[:size]}, nil
+}
+
+// Bytes 编码为带标签头与 PreviousTagSize 的完整字节。
+func (t Tag) Bytes() []byte {
+	size := len(t.Data)
+	b := make([]byte, tagHeaderSize+size+4)
+	b[0] = t.Type
+	b[1], b[2], b[3] = byte(size>>16), byte(size>>8), byte(size)
+	b[4], b[5], b[6], b[7] = byte(t.Timestamp>>16), byte(t.Timestamp>>8), byte(t.Timestamp), byte(t.Timestamp>>24)
+	copy(b[tagHeaderSize:], t.Data)
+	binary.BigEndian.PutUint32(b[tagHeaderSize+size:], uint32(tagHeaderSize+size))
+	return b
+}
+
+// IsKeyframe 判断是否为视频关键帧，兼容 Enhanced RTMP 扩展头。
+func (t Tag) IsKeyframe() bool {
+	if t.Type != TagVideo || len(t.Data) == 0 {
+		return false
+	}
+	return (t.Data[0]>>4)&0x07 == 1
+}
+
+// IsSequenceHeader 判断是否为解码配置（AVC/HEVC 序列头或 AAC AudioSpecificConfig）。
+func (t Tag) IsSequenceHeader() bool {
+	if len(t.Data) < 2 {
+		return false
+	}
+	switch t.Type {
+	case TagVideo:
+		if t.Data[0]&0x80 != 0 {
+			// Enhanced RTMP：低 4 位为包类型，0 表示 SequenceStart。
+			return t.Data[0]&0x0f == 0
+		}
+		codec := t.Data[0] & 0x0f
+		return (codec == 7 || codec == 12) && t.Data[1] == 0
+	case TagAudio:
+		return t.Data[0]>>4 == 10 && t.Data[1] == 0
+	}
+	return false
+}
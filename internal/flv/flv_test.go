@@ -0,0 +1,72 @@
+package flv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testHeader 为带音视频标志的 FLV 文件头与 PreviousTagSize0。
+var testHeader = []byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0}
+
+func TestTagRoundTrip(t *testing.T) {
+	tags := []Tag{
+		{Type: TagScript, Data: []byte("meta")},
+		{Type: TagVideo, Timestamp: 0x01020304, Data: []byte{0x17, 0x00, 0, 0, 0}},
+		{Type: TagAudio, Timestamp: 40, Data: []byte{0xaf, 0x01, 1, 2}},
+	}
+	var buf bytes.Buffer
+	buf.Write(testHeader)
+	for _, tag := range tags {
+		buf.Write(tag.Bytes())
+	}
+
+	header, err := ReadHeader(&buf)
+	if err != nil || !bytes.Equal(header, testHeader) {
+		t.Fatalf("unexpected header: %v, %v", header, err)
+	}
+	for _, want := range tags {
+		got, err := ReadTag(&buf)
+		if err != nil {
+			t.Fatalf("read tag failed: %v", err)
+		}
+		if got.Type != want.Type || got.Timestamp != want.Timestamp || !bytes.Equal(got.Data, want.Data) {
+			t.Fatalf("unexpected tag: %+v", got)
+		}
+	}
+	if _, err := ReadTag(&buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if _, err := ReadHeader(bytes.NewReader([]byte("#EXTM3U\n#EXT-X-"))); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected invalid header, got %v", err)
+	}
+}
+
+func TestTagClassification(t *testing.T) {
+	cases := []struct {
+		name     string
+		tag      Tag
+		keyframe bool
+		sequence bool
+	}{
+		{name: "avc sequence header", tag: Tag{Type: TagVideo, Data: []byte{0x17, 0x00}}, keyframe: true, sequence: true},
+		{name: "avc keyframe", tag: Tag{Type: TagVideo, Data: []byte{0x17, 0x01}}, keyframe: true},
+		{name: "avc inter frame", tag: Tag{Type: TagVideo, Data: []byte{0x27, 0x01}}},
+		{name: "hevc sequence header", tag: Tag{Type: TagVideo, Data: []byte{0x1c, 0x00}}, keyframe: true, sequence: true},
+		{name: "enhanced sequence start", tag: Tag{Type: TagVideo, Data: []byte{0x90, 'h', 'v', 'c', '1'}}, keyframe: true, sequence: true},
+		{name: "enhanced keyframe", tag: Tag{Type: TagVideo, Data: []byte{0x91, 'h', 'v', 'c', '1'}}, keyframe: true},
+		{name: "aac config", tag: Tag{Type: TagAudio, Data: []byte{0xaf, 0x00}}, sequence: true},
+		{name: "aac raw", tag: Tag{Type: TagAudio, Data: []byte{0xaf, 0x01}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.tag.IsKeyframe(); got != tc.keyframe {
+				t.Fatalf("IsKeyframe = %v", got)
+			}
+			if got := tc.tag.IsSequenceHeader(); got != tc.sequence {
+				t.Fatalf("IsSequenceHeader = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package flv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"PinkTide/internal/metrics"
+	"PinkTide/internal/origin"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/trace"
+)
+
+const (
+	// viewerBuffer 为每个观众可积压的标签数，超过后视为慢速观众并断开。
+	viewerBuffer = 1024
+	// maxGOPBytes 限制缓存的最近 GOP 大小，超出时新观众改为等待下一个关键帧。
+	maxGOPBytes = 16 << 20
+)
+
+// ErrSlowViewer 表示观众消费过慢被断开。
+var ErrSlowViewer = errors.New("flv viewer too slow")
+
+// Hub 为每个房间与清晰度维护一条上游 FLV 连接，并将标签流分发给所有观众。
+type Hub struct {
+	client *origin.Client
+	logger *slog.Logger
+	reject func(target string)
+	mu     sync.Mutex
+	relays map[string]*relay
+}
+
+// NewHub 创建分发器，client 应为不带整体超时的长连接客户端。
+func NewHub(client *origin.Client, logger *slog.Logger) *Hub {
+	return &Hub{
+		client: client,
+		logger: logger,
+		relays: make(map[string]*relay),
+	}
+}
+
+// OnReject 设置源站以 403/404 拒绝播放地址时的回调，需在首次订阅前调用。
+func (h *Hub) OnReject(fn func(target string)) {
+	h.reject = fn
+}
+
+// Subscribe 加入 key 对应的上游连接，首个观众触发回源；
+// 返回的 Viewer 先输出缓存的文件头、元数据、序列头与最近 GOP，调用方结束时需 Close。
+func (h *Hub) Subscribe(ctx context.Context, key string, source stream.Source) (*Viewer, error) {
+	h.mu.Lock()
+	r, ok := h.relays[key]
+	if !ok {
+		upstream, cancel := context.WithCancel(trace.Background(context.Background()))
+		r = &relay{
+			key:     key,
+			cancel:  cancel,
+			ready:   make(chan struct{}),
+			viewers: make(map[*Viewer]struct{}),
+		}
+		h.relays[key] = r
+		go h.run(upstream, r, source)
+	}
+	r.pending++
+	h.mu.Unlock()
+	metrics.Flights.Inc("flv", metrics.FlightResult(ok))
+
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		h.leave(r, nil)
+		return nil, ctx.Err()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.pending--
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	}
+	v := &Viewer{hub: h, relay: r, ch: make(chan []byte, viewerBuffer), done: make(chan struct{})}
+	v.ch <- r.prelude()
+	v.waitKey = len(r.gop) == 0
+	r.viewers[v] = struct{}{}
+	return v, nil
+}
+
+// Viewers 返回 key 对应上游连接当前的观众数。
+func (h *Hub) Viewers(key string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.relays[key]
+	if !ok {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.viewers)
+}
+
+// Close 断开全部上游连接。
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.relays {
+		r.cancel()
+	}
+}
+
+// run 建立上游连接并持续读取标签，结束时通知所有观众。
+func (h *Hub) run(ctx context.Context, r *relay, source stream.Source) {
+	err := h.pump(ctx, r, source)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if h.logger != nil {
+		if err != nil {
+			h.logger.Warn("flv relay stopped", "key", r.key, "error", err)
+		} else {
+			h.logger.Debug("flv relay stopped", "key", r.key)
+		}
+	}
+
+	h.mu.Lock()
+	if h.relays[r.key] == r {
+		delete(h.relays, r.key)
+	}
+	r.mu.Lock()
+	r.closed = true
+	r.err = err
+	for v := range r.viewers {
+		v.stop(err)
+	}
+	r.viewers = nil
+	r.mu.Unlock()
+	h.mu.Unlock()
+	r.readyOnce.Do(func() { close(r.ready) })
+	r.cancel()
+}
+
+// pump 回源并逐个发布标签，直到上游结束或连接被取消。
+func (h *Hub) pump(ctx context.Context, r *relay, source stream.Source) error {
+	target, err := source(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", stream.ErrSourceUnavailable, err)
+	}
+	if target == "" {
+		return stream.ErrSourceUnavailable
+	}
+	resp, err := h.client.Open(ctx, target, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if stream.IsRejected(resp.StatusCode) && h.reject != nil {
+			h.reject(target)
+		}
+		return fmt.Errorf("origin status %d", resp.StatusCode)
+	}
+
+	br := bufio.NewReaderSize(resp.Body, 64<<10)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.header = header
+	r.mu.Unlock()
+	r.readyOnce.Do(func() { close(r.ready) })
+	if h.logger != nil {
+		h.logger.Debug("flv relay started", "key", r.key)
+	}
+
+	for {
+		tag, err := ReadTag(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		r.publish(tag)
+	}
+}
+
+// leave 注销观众或未完成的订阅，最后一名观众离开时断开上游。
+func (h *Hub) leave(r *relay, v *Viewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.mu.Lock()
+	if v != nil {
+		delete(r.viewers, v)
+	} else {
+		r.pending--
+	}
+	idle := !r.closed && len(r.viewers) == 0 && r.pending == 0
+	if idle {
+		r.closed = true
+		if h.relays[r.key] == r {
+			delete(h.relays, r.key)
+		}
+	}
+	r.mu.Unlock()
+	if idle {
+		r.cancel()
+	}
+}
+
+// relay 保存一条上游连接的起播缓存与观众列表。
+type relay struct {
+	key       string
+	cancel    context.CancelFunc
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu       sync.Mutex
+	header   []byte
+	metadata []byte
+	videoSeq []byte
+	audioSeq []byte
+	gop      [][]byte
+	gopBytes int
+	viewers  map[*Viewer]struct{}
+	pending  int
+	closed   bool
+	err      error
+}
+
+// prelude 拼接新观众的起播数据。
+func (r *relay) prelude() []byte {
+	size := len(r.header) + len(r.metadata) + len(r.videoSeq) + len(r.audioSeq) + r.gopBytes
+	b := make([]byte, 0, size)
+	b = append(b, r.header...)
+	b = append(b, r.metadata...)
+	b = append(b, r.videoSeq...)
+	b = append(b, r.audioSeq...)
+	for _, tag := range r.gop {
+		b = append(b, tag...)
+	}
+	return b
+}
+
+// publish 更新起播缓存并把标签分发给所有观众，积压过多的观众被断开。
+func (r *relay) publish(tag Tag) {
+	b := tag.Bytes()
+	keyframe := tag.IsKeyframe() && !tag.IsSequenceHeader()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case tag.Type == TagScript:
+		r.metadata = b
+	case tag.IsSequenceHeader():
+		if tag.Type == TagVideo {
+			r.videoSeq = b
+		} else {
+			r.audioSeq = b
+		}
+	case keyframe:
+		r.gop = append(r.gop[:0:0], b)
+		r.gopBytes = len(b)
+	case len(r.gop) > 0:
+		if r.gopBytes+len(b) > maxGOPBytes {
+			r.gop, r.gopBytes = nil, 0
+		} else {
+			r.gop = append(r.gop, b)
+			r.gopBytes += len(b)
+		}
+	}
+
+	for v := range r.viewers {
+		if v.waitKey {
+			if tag.Type == TagVideo && !keyframe && !tag.IsSequenceHeader() {
+				continue
+			}
+			if keyframe {
+				v.waitKey = false
+			}
+		}
+		select {
+		case v.ch <- b:
+		default:
+			delete(r.viewers, v)
+			v.stop(ErrSlowViewer)
+		}
+	}
+}
+
+// Viewer 为一个观众的标签流。
+type Viewer struct {
+	hub      *Hub
+	relay    *relay
+	ch       chan []byte
+	done     chan struct{}
+	err      error
+	waitKey  bool
+	stopOnce sync.Once
+}
+
+// Next 返回下一段待写出的数据，上游结束时返回 io.EOF 或上游错误。
+func (v *Viewer) Next(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-v.ch:
+		return b, nil
+	default:
+	}
+	select {
+	case b := <-v.ch:
+		return b, nil
+	case <-v.done:
+		select {
+		case b := <-v.ch:
+			return b, nil
+		default:
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close 离开分发，最后一名观众离开时断开上游连接。
+func (v *Viewer) Close() {
+	v.hub.leave(v.relay, v)
+}
+
+// stop 结束观众的数据流，调用方需持有 relay 锁。
+func (v *Viewer) stop(err error) {
+	v.stopOnce.Do(func() {
+		v.err = err
+		close(v.done)
+	})
+}
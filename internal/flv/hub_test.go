@@ -0,0 +1,102 @@
+package flv
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PinkTide/internal/origin"
+)
+
+func TestHubFanOutAndTeardown(t *testing.T) {
+	metadata := Tag{Type: TagScript, Data: []byte("onMetaData")}.Bytes()
+	videoSeq := Tag{Type: TagVideo, Data: []byte{0x17, 0x00, 1}}.Bytes()
+	keyframe := Tag{Type: TagVideo, Timestamp: 1000, Data: []byte{0x17, 0x01, 2}}.Bytes()
+	frames := make(chan []byte, 16)
+
+	var (
+		connections atomic.Int32
+		closed      = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "video/x-flv")
+		_, _ = w.Write(testHeader)
+		_, _ = w.Write(metadata)
+		_, _ = w.Write(videoSeq)
+		_, _ = w.Write(keyframe)
+		flusher.Flush()
+		for {
+			select {
+			case b := <-frames:
+				_, _ = w.Write(b)
+				flusher.Flush()
+			case <-r.Context().Done():
+				close(closed)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	hub := NewHub(origin.NewClient(time.Second, nil).Streaming(), nil)
+	defer hub.Close()
+	source := func(context.Context) (string, error) { return srv.URL + "/live.flv", nil }
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	first, err := hub.Subscribe(ctx, "room", source)
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	prelude, err := first.Next(ctx)
+	if err != nil || !bytes.HasPrefix(prelude, testHeader) {
+		t.Fatalf("unexpected prelude: %v, %v", prelude, err)
+	}
+	// 等待上游把关键帧送达后再加入第二名观众。
+	for received := len(prelude); received < len(testHeader)+len(metadata)+len(videoSeq)+len(keyframe); {
+		b, err := first.Next(ctx)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		received += len(b)
+	}
+
+	second, err := hub.Subscribe(ctx, "room", source)
+	if err != nil {
+		t.Fatalf("second subscribe failed: %v", err)
+	}
+	prelude, err = second.Next(ctx)
+	want := append(append(append(append([]byte{}, testHeader...), metadata...), videoSeq...), keyframe...)
+	if err != nil || !bytes.Equal(prelude, want) {
+		t.Fatalf("late viewer should start from cached header and keyframe: %v, %v", prelude, err)
+	}
+	if n := connections.Load(); n != 1 {
+		t.Fatalf("expected a single upstream connection, got %d", n)
+	}
+
+	inter := Tag{Type: TagVideo, Timestamp: 1040, Data: []byte{0x27, 0x01, 3}}.Bytes()
+	frames <- inter
+	for _, v := range []*Viewer{first, second} {
+		b, err := v.Next(ctx)
+		if err != nil || !bytes.Equal(b, inter) {
+			t.Fatalf("unexpected fan-out frame: %v, %v", b, err)
+		}
+	}
+
+	first.Close()
+	if n := hub.Viewers("room"); n != 1 {
+		t.Fatalf("expected one remaining viewer, got %d", n)
+	}
+	second.Close()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		t.Fatalf("expected upstream to be closed after last viewer left")
+	}
+}
@@ -0,0 +1,50 @@
+// Package httputil 提供各 HTTP 中间件共用的响应包装。
+package httputil
+
+import "net/http"
+
+// Recorder 记录处理器写出的状态码，并透传 Flush 与 Unwrap 以支持流式响应与 ResponseController。
+type Recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// NewRecorder 包装 w；w 已是 Recorder 时直接返回，使多层中间件共用同一包装。
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	if rec, ok := w.(*Recorder); ok {
+		return rec
+	}
+	return &Recorder{ResponseWriter: w}
+}
+
+// Status 返回写出的状态码，未写出响应头时按 200 计。
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
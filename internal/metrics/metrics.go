@@ -0,0 +1,58 @@
+// Package metrics 提供不依赖第三方库的 Prometheus 文本格式指标，以及 PinkTide 各模块共用的指标定义。
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"PinkTide/internal/httputil"
+)
+
+// Default 为进程级注册表，下列指标均注册于此并由 /metrics 输出。
+var Default = NewRegistry()
+
+var (
+	// HTTPRequests 按路由与状态码统计请求数。
+	HTTPRequests = Default.NewCounterVec("pinktide_http_requests_total", "HTTP requests by route and status code.", "route", "status")
+	// HTTPDuration 按路由统计请求处理时长，长连接路由（/live.flv、/api/watch）为连接持续时间。
+	HTTPDuration = Default.NewHistogramVec("pinktide_http_request_duration_seconds", "HTTP request duration by route.", DefBuckets, "route")
+	// OriginLatency 按主机统计回源请求收到响应头的耗时。
+	OriginLatency = Default.NewHistogramVec("pinktide_origin_request_duration_seconds", "Origin request latency until response headers, by host.", DefBuckets, "host")
+	// OriginErrors 按主机统计未取得响应的回源请求数。
+	OriginErrors = Default.NewCounterVec("pinktide_origin_request_errors_total", "Origin requests that failed before a response, by host.", "host")
+	// SegmentBytes 统计 /seg 向客户端写出的切片字节数，由切片处理器在写出后累加。
+	SegmentBytes = Default.NewCounterVec("pinktide_segment_bytes_served_total", "Segment bytes written to clients.")
+	// Flights 按合并组统计并发合并调用中实际执行（executed）与复用进行中结果（shared）的次数。
+	Flights = Default.NewCounterVec("pinktide_singleflight_calls_total", "Deduplicated calls by group and result (executed or shared).", "group", "result")
+	// BiliAPIErrors 按错误码统计 B 站接口错误，HTTP 状态异常记为 http_<status>，网络与解析错误分别记为 network、decode。
+	BiliAPIErrors = Default.NewCounterVec("pinktide_bili_api_errors_total", "Bilibili API errors by code.", "api", "code")
+	// ResolverRefreshes 按结果统计播放地址刷新次数。
+	ResolverRefreshes = Default.NewCounterVec("pinktide_resolver_refreshes_total", "Play URL refreshes by outcome.", "outcome")
+	// SSEWatchers 为当前 /api/watch 连接数。
+	SSEWatchers = Default.NewGauge("pinktide_sse_watchers", "Active /api/watch server-sent event streams.")
+)
+
+// ObserveSince 记录自 start 起经过的秒数。
+func ObserveSince(h *HistogramVec, start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+// FlightResult 将合并调用是否复用转换为标签值。
+func FlightResult(shared bool) string {
+	if shared {
+		return "shared"
+	}
+	return "executed"
+}
+
+// Instrument 包装处理器，按 route 统计请求数、状态码与处理时长；route 应为注册路由而非原始路径，避免标签基数失控。
+func Instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := httputil.NewRecorder(w)
+		next.ServeHTTP(rec, r)
+		HTTPRequests.Inc(route, strconv.Itoa(rec.Status()))
+		ObserveSince(HTTPDuration, start, route)
+	})
+}
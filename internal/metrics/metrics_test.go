@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesTextFormat(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("test_requests_total", "Requests.", "route", "status")
+	latency := reg.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "host")
+	watchers := reg.NewGauge("test_watchers", "Watchers.")
+
+	requests.Inc("/seg", "200")
+	requests.Add(2, "/live.m3u8", "202")
+	latency.Observe(0.05, "a.example.com")
+	latency.Observe(0.5, "a.example.com")
+	latency.Observe(3, "a.example.com")
+	watchers.Inc()
+	watchers.Inc()
+	watchers.Dec()
+
+	var b strings.Builder
+	if err := reg.Write(&b); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/live.m3u8",status="202"} 2
+test_requests_total{route="/seg",status="200"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{host="a.example.com",le="0.1"} 1
+test_latency_seconds_bucket{host="a.example.com",le="1"} 2
+test_latency_seconds_bucket{host="a.example.com",le="+Inf"} 3
+test_latency_seconds_sum{host="a.example.com"} 3.55
+test_latency_seconds_count{host="a.example.com"} 3
+# HELP test_watchers Watchers.
+# TYPE test_watchers gauge
+test_watchers 1
+`
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounterVec("test_total", "Line one\nline two.", "code")
+	c.Inc(`a"b\c`)
+	var b strings.Builder
+	_ = reg.Write(&b)
+	if !strings.Contains(b.String(), `# HELP test_total Line one\nline two.`) || !strings.Contains(b.String(), `test_total{code="a\"b\\c"} 1`) {
+		t.Fatalf("unexpected escaping:\n%s", b.String())
+	}
+}
+
+func TestInstrumentCountsStatus(t *testing.T) {
+	handler := Instrument("/seg", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected flusher to be preserved")
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	before := HTTPRequests.Value("/seg", "206")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/seg", nil))
+	if got := HTTPRequests.Value("/seg", "206") - before; got != 1 {
+		t.Fatalf("expected one request, got %v", got)
+	}
+}
@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
+
+	"PinkTide/internal/metrics"
+	"PinkTide/internal/trace"
 )
 
 // Client 封装回源请求，统一超时与头部伪装策略。
 type Client struct {
 	httpClient *http.Client
 	headers    http.Header
+	policy     *Policy
 }
 
 // NewClient 创建回源客户端，timeout 控制整体请求超时。
@@ -27,27 +32,101 @@ func NewClient(timeout time.Duration, headers map[string]string) *Client {
 	}
 }
 
-// Get 执行回源请求并返回响应体与状态码，请求失败返回错误。
-func (c *Client) Get(ctx context.Context, target string) ([]byte, int, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
-	if err != nil {
-		return nil, 0, fmt.Errorf("create request failed: %w", err)
+// Streaming 返回适用于长连接的客户端：取消整体超时，仅限制等待响应头的时间，连接生命周期由 ctx 控制。
+func (c *Client) Streaming() *Client {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
 	}
+	transport = transport.Clone()
+	transport.ResponseHeaderTimeout = c.httpClient.Timeout
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	httpClient.Transport = transport
+	return &Client{httpClient: &httpClient, headers: c.headers, policy: c.policy}
+}
 
-	for k, v := range c.headers {
-		req.Header[k] = append([]string(nil), v...)
+// Get 执行回源请求并返回响应体与状态码，请求失败返回错误；span 只记录主机与路径，不记录含鉴权参数的查询串。
+func (c *Client) Get(ctx context.Context, target string) (data []byte, status int, err error) {
+	ctx, span := trace.Start(ctx, "origin.Get", trace.KindClient)
+	defer func() {
+		if status != 0 {
+			span.SetAttr("http.response.status_code", status)
+		}
+		switch {
+		case err != nil:
+			span.SetError(err)
+		case status >= http.StatusBadRequest:
+			span.SetError(fmt.Errorf("origin status %d", status))
+		}
+		span.End()
+	}()
+	if u, parseErr := url.Parse(target); parseErr == nil {
+		span.SetAttr("server.address", u.Host)
+		span.SetAttr("url.path", u.Path)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.open(ctx, target, nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
-	data, err := io.ReadAll(resp.Body)
+	data, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, resp.StatusCode, fmt.Errorf("read response failed: %w", err)
 	}
 
 	return data, resp.StatusCode, nil
 }
+
+// Open 执行回源请求并返回未读取的响应，extra 用于追加 Range 等请求头，调用方负责关闭 Body；
+// span 记录至收到响应头为止，同样不记录查询串。
+func (c *Client) Open(ctx context.Context, target string, extra http.Header) (resp *http.Response, err error) {
+	ctx, span := trace.Start(ctx, "origin.Open", trace.KindClient)
+	defer func() {
+		switch {
+		case err != nil:
+			span.SetError(err)
+		default:
+			span.SetAttr("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode >= http.StatusBadRequest {
+				span.SetError(fmt.Errorf("origin status %d", resp.StatusCode))
+			}
+		}
+		span.End()
+	}()
+
+	if u, parseErr := url.Parse(target); parseErr == nil {
+		span.SetAttr("server.address", u.Host)
+		span.SetAttr("url.path", u.Path)
+	}
+	return c.open(ctx, target, extra)
+}
+
+// open 执行回源请求，不单独创建 span，由 Get 与 Open 各自记录。
+func (c *Client) open(ctx context.Context, target string, extra http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	if err := c.checkTarget(req.URL); err != nil {
+		return nil, err
+	}
+
+	for k, v := range c.headers {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	for k, v := range extra {
+		req.Header[k] = append([]string(nil), v...)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		metrics.OriginErrors.Inc(req.URL.Host)
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	metrics.ObserveSince(metrics.OriginLatency, start, req.URL.Host)
+	return resp, nil
+}
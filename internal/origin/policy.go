@@ -0,0 +1,141 @@
+package origin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrDenied 表示回源目标被策略拒绝。
+var ErrDenied = errors.New("origin target denied")
+
+// cgnatBlock 为运营商级 NAT 地址段，同样视为内网地址。
+var cgnatBlock = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Policy 限制回源可访问的协议与主机，并在 DNS 解析后拦截内网、回环与链路本地地址。
+type Policy struct {
+	schemes      map[string]bool
+	hosts        []string
+	blockPrivate bool
+}
+
+// NewPolicy 创建回源策略：schemes 为空时不限制协议，hosts 为空时不限制主机；
+// hosts 支持精确域名与 "*.example.com" 后缀通配。
+func NewPolicy(schemes, hosts []string, blockPrivate bool) *Policy {
+	p := &Policy{schemes: make(map[string]bool, len(schemes)), blockPrivate: blockPrivate}
+	for _, scheme := range schemes {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" {
+			p.schemes[scheme] = true
+		}
+	}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			p.hosts = append(p.hosts, host)
+		}
+	}
+	return p
+}
+
+// CheckURL 校验协议与主机是否在允许范围内。
+func (p *Policy) CheckURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if len(p.schemes) > 0 && !p.schemes[scheme] {
+		return fmt.Errorf("%w: scheme %q", ErrDenied, u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: empty host", ErrDenied)
+	}
+	if !p.allowHost(host) {
+		return fmt.Errorf("%w: host %q", ErrDenied, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(ip)
+	}
+	return nil
+}
+
+// allowHost 判断主机是否匹配白名单。
+func (p *Policy) allowHost(host string) bool {
+	if len(p.hosts) == 0 {
+		return true
+	}
+	for _, pattern := range p.hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIP 拦截内网、回环、链路本地等不应由代理访问的地址。
+func (p *Policy) checkIP(ip net.IP) error {
+	if !p.blockPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatBlock.Contains(ip) {
+		return fmt.Errorf("%w: address %s", ErrDenied, ip)
+	}
+	return nil
+}
+
+// control 在建立连接前校验解析后的地址，避免 DNS 解析到内网绕过主机白名单。
+func (p *Policy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: address %q", ErrDenied, address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: address %q", ErrDenied, address)
+	}
+	return p.checkIP(ip)
+}
+
+// WithPolicy 返回受策略约束的回源客户端，共享请求头与超时配置；
+// 拦截内网地址时不使用环境变量代理，以便校验真实目标地址。
+func (c *Client) WithPolicy(p *Policy) *Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if p.blockPrivate {
+		transport.Proxy = nil
+	}
+	httpClient := &http.Client{
+		Timeout:   c.httpClient.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.CheckURL(req.URL)
+		},
+	}
+	return &Client{httpClient: httpClient, headers: c.headers, policy: p}
+}
+
+// checkTarget 在发起请求前执行策略校验，未配置策略时直接放行。
+func (c *Client) checkTarget(u *url.URL) error {
+	if c.policy == nil {
+		return nil
+	}
+	return c.policy.CheckURL(u)
+}
@@ -0,0 +1,86 @@
+package origin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	p := NewPolicy([]string{"https"}, []string{"*.bilivideo.com", "live.example.com"}, true)
+
+	cases := []struct {
+		name    string
+		target  string
+		allowed bool
+	}{
+		{name: "suffix match", target: "https://cn-gd-fx-01.bilivideo.com/live/seg.ts", allowed: true},
+		{name: "exact match", target: "https://live.example.com/seg.ts", allowed: true},
+		{name: "bare suffix domain", target: "https://bilivideo.com/seg.ts", allowed: false},
+		{name: "lookalike suffix", target: "https://evilbilivideo.com/seg.ts", allowed: false},
+		{name: "scheme denied", target: "http://cn.bilivideo.com/seg.ts", allowed: false},
+		{name: "host denied", target: "https://example.org/seg.ts", allowed: false},
+		{name: "file scheme", target: "file:///etc/passwd", allowed: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.target)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			err = p.CheckURL(u)
+			if tc.allowed && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.allowed && !errors.Is(err, ErrDenied) {
+				t.Fatalf("expected denial, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPolicyBlocksPrivateAddresses(t *testing.T) {
+	p := NewPolicy(nil, nil, true)
+	for _, target := range []string{
+		"http://127.0.0.1/",
+		"http://10.0.0.8/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.64.0.1/",
+		"http://[::1]/",
+		"http://[fe80::1]/",
+	} {
+		u, _ := url.Parse(target)
+		if err := p.CheckURL(u); !errors.Is(err, ErrDenied) {
+			t.Fatalf("expected %s to be denied, got %v", target, err)
+		}
+	}
+	u, _ := url.Parse("http://1.1.1.1/")
+	if err := p.CheckURL(u); err != nil {
+		t.Fatalf("unexpected error for public address: %v", err)
+	}
+}
+
+func TestClientWithPolicyBlocksResolvedAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	target := "http://localhost:" + u.Port() + "/"
+
+	blocked := NewClient(time.Second, nil).WithPolicy(NewPolicy(nil, []string{"localhost"}, true))
+	if _, _, err := blocked.Get(context.Background(), target); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected resolved loopback to be denied, got %v", err)
+	}
+
+	allowed := NewClient(time.Second, nil).WithPolicy(NewPolicy(nil, []string{"localhost"}, false))
+	data, status, err := allowed.Get(context.Background(), target)
+	if err != nil || status != http.StatusOK || string(data) != "ok" {
+		t.Fatalf("unexpected result: %q, %d, %v", data, status, err)
+	}
+}
@@ -0,0 +1,473 @@
+package playlist
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Playlist 为解析后的媒体或主播放列表。
+//
+// 内容按行划分为头部标签、以 URI 行结束的切片或变体、以及末尾标签三部分，每个标签保留原始文本，
+// 未修改时 String 输出与输入逐字节一致（混用 CRLF 与 LF 时统一为 CRLF）。
+// 全局字段与切片的时长、序号等字段为解析结果，修改内容需通过标签或 Segment 的 Set 方法。
+type Playlist struct {
+	Master   bool
+	Header   []*Tag
+	Segments []*Segment
+	Variants []*Variant
+	Trailer  []*Tag
+
+	Version               int
+	TargetDuration        time.Duration
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string
+	PartTarget            time.Duration
+	Ended                 bool
+
+	newline         string
+	trailingNewline bool
+}
+
+// Segment 为媒体播放列表中的一个切片，Tags 为其 URI 之前的全部标签、注释与空行。
+type Segment struct {
+	Tags []*Tag
+	URI  string
+
+	Sequence        int64
+	Duration        time.Duration
+	Title           string
+	Discontinuity   bool
+	ProgramDateTime time.Time
+	// Map 与 Key 为对该切片生效的 EXT-X-MAP 与 EXT-X-KEY，可能声明于之前的切片。
+	Map        *Tag
+	Key        *Tag
+	DateRanges []*Tag
+	Parts      []*Tag
+
+	rawURI string
+}
+
+// Variant 为主播放列表中的一个变体流，Stream 为其 EXT-X-STREAM-INF 标签。
+type Variant struct {
+	Tags []*Tag
+	URI  string
+
+	Stream     *Tag
+	Bandwidth  int64
+	Resolution string
+	Codecs     string
+
+	rawURI string
+}
+
+// headerTags 为出现在首个切片之前时归入头部的播放列表级标签。
+var headerTags = map[string]bool{
+	"#EXTM3U":                       true,
+	"#EXT-X-VERSION":                true,
+	"#EXT-X-TARGETDURATION":         true,
+	"#EXT-X-MEDIA-SEQUENCE":         true,
+	"#EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"#EXT-X-PLAYLIST-TYPE":          true,
+	"#EXT-X-INDEPENDENT-SEGMENTS":   true,
+	"#EXT-X-START":                  true,
+	"#EXT-X-SERVER-CONTROL":         true,
+	"#EXT-X-PART-INF":               true,
+	"#EXT-X-I-FRAMES-ONLY":          true,
+	"#EXT-X-ALLOW-CACHE":            true,
+}
+
+// Parse 解析播放列表，任意输入都能解析；包含 EXT-X-STREAM-INF 时按主播放列表处理。
+func Parse(content string) *Playlist {
+	p := &Playlist{newline: "\n"}
+	if strings.Contains(content, "\r\n") {
+		p.newline = "\r\n"
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if strings.HasSuffix(content, "\n") {
+		p.trailingNewline = true
+		content = content[:len(content)-1]
+	}
+	var lines []string
+	if content != "" || p.trailingNewline {
+		lines = strings.Split(content, "\n")
+	}
+
+	type group struct {
+		tags []*Tag
+		uri  string
+	}
+	var (
+		groups  []group
+		pending []*Tag
+		inBody  bool
+	)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			groups = append(groups, group{tags: pending, uri: line})
+			pending, inBody = nil, true
+			continue
+		}
+		tag := parseTag(line)
+		if name := strings.TrimSpace(tag.Name); name == "#EXT-X-STREAM-INF" {
+			p.Master = true
+		}
+		if !inBody && (headerTags[strings.TrimSpace(tag.Name)] || !strings.HasPrefix(tag.Name, "#EXT")) {
+			p.Header = append(p.Header, tag)
+			continue
+		}
+		inBody = true
+		pending = append(pending, tag)
+	}
+	p.Trailer = pending
+
+	p.parseGlobals()
+	var mapTag, keyTag *Tag
+	for i, g := range groups {
+		if p.Master {
+			p.Variants = append(p.Variants, newVariant(g.tags, g.uri))
+			continue
+		}
+		seg := &Segment{Tags: g.tags, URI: strings.TrimSpace(g.uri), Sequence: p.MediaSequence + int64(i), rawURI: g.uri}
+		for _, tag := range g.tags {
+			value := strings.TrimSpace(tag.Value)
+			switch strings.TrimSpace(tag.Name) {
+			case "#EXTINF":
+				duration, title, _ := strings.Cut(value, ",")
+				if seconds, err := strconv.ParseFloat(strings.TrimSpace(duration), 64); err == nil && seconds >= 0 {
+					seg.Duration = seconds2duration(seconds)
+				}
+				seg.Title = title
+			case "#EXT-X-DISCONTINUITY":
+				seg.Discontinuity = true
+			case "#EXT-X-PROGRAM-DATE-TIME":
+				if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+					seg.ProgramDateTime = t
+				}
+			case "#EXT-X-MAP":
+				mapTag = tag
+			case "#EXT-X-KEY":
+				keyTag = tag
+				if method, _ := tag.Attr("METHOD"); method == "NONE" {
+					keyTag = nil
+				}
+			case "#EXT-X-DATERANGE":
+				seg.DateRanges = append(seg.DateRanges, tag)
+			case "#EXT-X-PART":
+				seg.Parts = append(seg.Parts, tag)
+			}
+		}
+		seg.Map, seg.Key = mapTag, keyTag
+		p.Segments = append(p.Segments, seg)
+	}
+	return p
+}
+
+// parseGlobals 读取播放列表级字段。
+func (p *Playlist) parseGlobals() {
+	for _, tag := range p.Tags() {
+		value := strings.TrimSpace(tag.Value)
+		switch strings.TrimSpace(tag.Name) {
+		case "#EXT-X-VERSION":
+			if v, err := strconv.Atoi(value); err == nil {
+				p.Version = v
+			}
+		case "#EXT-X-TARGETDURATION":
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v > 0 {
+				p.TargetDuration = seconds2duration(v)
+			}
+		case "#EXT-X-MEDIA-SEQUENCE":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.MediaSequence = v
+			}
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.DiscontinuitySequence = v
+			}
+		case "#EXT-X-PLAYLIST-TYPE":
+			p.PlaylistType = value
+		case "#EXT-X-PART-INF":
+			if v, ok := tag.Attr("PART-TARGET"); ok {
+				if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+					p.PartTarget = seconds2duration(seconds)
+				}
+			}
+		case "#EXT-X-ENDLIST":
+			p.Ended = true
+		}
+	}
+}
+
+// newVariant 从 URI 之前的标签中读取 EXT-X-STREAM-INF 属性。
+func newVariant(tags []*Tag, uri string) *Variant {
+	v := &Variant{Tags: tags, URI: strings.TrimSpace(uri), rawURI: uri}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag.Name) != "#EXT-X-STREAM-INF" {
+			continue
+		}
+		v.Stream = tag
+		if bw, ok := tag.Attr("BANDWIDTH"); ok {
+			v.Bandwidth, _ = strconv.ParseInt(bw, 10, 64)
+		}
+		v.Resolution, _ = tag.Attr("RESOLUTION")
+		v.Codecs, _ = tag.Attr("CODECS")
+	}
+	return v
+}
+
+// Tags 按出现顺序返回全部标签，包括切片与变体内的标签。
+func (p *Playlist) Tags() []*Tag {
+	tags := append([]*Tag(nil), p.Header...)
+	for _, seg := range p.Segments {
+		tags = append(tags, seg.Tags...)
+	}
+	for _, v := range p.Variants {
+		tags = append(tags, v.Tags...)
+	}
+	return append(tags, p.Trailer...)
+}
+
+// Tag 返回首个同名标签，不存在时返回 nil。
+func (p *Playlist) Tag(name string) *Tag {
+	for _, tag := range p.Tags() {
+		if strings.TrimSpace(tag.Name) == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Renditions 返回主播放列表中的 EXT-X-MEDIA 标签。
+func (p *Playlist) Renditions() []*Tag {
+	var tags []*Tag
+	for _, tag := range p.Tags() {
+		if strings.TrimSpace(tag.Name) == "#EXT-X-MEDIA" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// PendingParts 返回最后一个完整切片之后已发布的 LL-HLS 分片数。
+func (p *Playlist) PendingParts() int {
+	n := 0
+	for _, tag := range p.Trailer {
+		if strings.TrimSpace(tag.Name) == "#EXT-X-PART" {
+			n++
+		}
+	}
+	return n
+}
+
+// String 按原有换行风格输出播放列表。
+func (p *Playlist) String() string {
+	var lines []string
+	for _, tag := range p.Header {
+		lines = append(lines, tag.String())
+	}
+	for _, seg := range p.Segments {
+		for _, tag := range seg.Tags {
+			lines = append(lines, tag.String())
+		}
+		lines = append(lines, uriLine(seg.URI, seg.rawURI))
+	}
+	for _, v := range p.Variants {
+		for _, tag := range v.Tags {
+			lines = append(lines, tag.String())
+		}
+		lines = append(lines, uriLine(v.URI, v.rawURI))
+	}
+	for _, tag := range p.Trailer {
+		lines = append(lines, tag.String())
+	}
+	out := strings.Join(lines, p.newline)
+	if p.trailingNewline {
+		out += p.newline
+	}
+	return out
+}
+
+// uriLine 在 URI 未修改时保留原始行（包括两侧空白）。
+func uriLine(uri, raw string) string {
+	if uri == strings.TrimSpace(raw) {
+		return raw
+	}
+	return uri
+}
+
+// NewMedia 创建只含 #EXTM3U 的媒体播放列表，用于生成输出，以 LF 换行并以换行结尾。
+func NewMedia() *Playlist {
+	return &Playlist{Header: []*Tag{NewTag("#EXTM3U", "")}, newline: "\n", trailingNewline: true}
+}
+
+// SetVersion 设置 EXT-X-VERSION。
+func (p *Playlist) SetVersion(v int) {
+	p.Version = v
+	p.setHeader("#EXT-X-VERSION", strconv.Itoa(v))
+}
+
+// SetPlaylistType 设置 EXT-X-PLAYLIST-TYPE（EVENT 或 VOD）。
+func (p *Playlist) SetPlaylistType(t string) {
+	p.PlaylistType = t
+	p.setHeader("#EXT-X-PLAYLIST-TYPE", t)
+}
+
+// SetTargetDuration 设置 EXT-X-TARGETDURATION，按规范向上取整到秒且至少为 1 秒。
+func (p *Playlist) SetTargetDuration(d time.Duration) {
+	seconds := max(int(math.Ceil(d.Seconds())), 1)
+	p.TargetDuration = time.Duration(seconds) * time.Second
+	p.setHeader("#EXT-X-TARGETDURATION", strconv.Itoa(seconds))
+}
+
+// SetMediaSequence 设置 EXT-X-MEDIA-SEQUENCE 并重新编号已有切片。
+func (p *Playlist) SetMediaSequence(n int64) {
+	p.MediaSequence = n
+	p.setHeader("#EXT-X-MEDIA-SEQUENCE", strconv.FormatInt(n, 10))
+	for i, seg := range p.Segments {
+		seg.Sequence = n + int64(i)
+	}
+}
+
+// SetDiscontinuitySequence 设置 EXT-X-DISCONTINUITY-SEQUENCE，0 表示省略该标签。
+func (p *Playlist) SetDiscontinuitySequence(n int64) {
+	p.DiscontinuitySequence = n
+	if n == 0 {
+		p.Header = removeTag(p.Header, "#EXT-X-DISCONTINUITY-SEQUENCE")
+		return
+	}
+	p.setHeader("#EXT-X-DISCONTINUITY-SEQUENCE", strconv.FormatInt(n, 10))
+}
+
+// SetEnded 在末尾追加或移除 EXT-X-ENDLIST。
+func (p *Playlist) SetEnded(ended bool) {
+	p.Ended = ended
+	p.Trailer = removeTag(p.Trailer, "#EXT-X-ENDLIST")
+	if ended {
+		p.Trailer = append(p.Trailer, NewTag("#EXT-X-ENDLIST", ""))
+	}
+}
+
+// AppendSegment 追加切片，序号接续 EXT-X-MEDIA-SEQUENCE。
+func (p *Playlist) AppendSegment(uri string, d time.Duration) *Segment {
+	seg := &Segment{URI: uri, Sequence: p.MediaSequence + int64(len(p.Segments))}
+	seg.SetDuration(d)
+	p.Segments = append(p.Segments, seg)
+	return seg
+}
+
+// setHeader 更新头部标签的值，标签不存在时追加到头部末尾。
+func (p *Playlist) setHeader(name, value string) {
+	for _, tag := range p.Header {
+		if strings.TrimSpace(tag.Name) == name {
+			tag.Value, tag.colon = value, true
+			return
+		}
+	}
+	p.Header = append(p.Header, NewTag(name, value))
+}
+
+// SetDuration 修改切片时长并同步 EXTINF 标签，标题保持不变。
+func (s *Segment) SetDuration(d time.Duration) {
+	s.Duration = d
+	value := fmt.Sprintf("%.3f,%s", d.Seconds(), s.Title)
+	if tag := s.tag("#EXTINF"); tag != nil {
+		tag.Value, tag.colon = value, true
+		return
+	}
+	s.Tags = append(s.Tags, NewTag("#EXTINF", value))
+}
+
+// SetDiscontinuity 设置或移除切片前的 EXT-X-DISCONTINUITY。
+func (s *Segment) SetDiscontinuity(on bool) {
+	s.Discontinuity = on
+	if on {
+		if s.tag("#EXT-X-DISCONTINUITY") == nil {
+			s.Tags = append([]*Tag{NewTag("#EXT-X-DISCONTINUITY", "")}, s.Tags...)
+		}
+		return
+	}
+	s.removeTag("#EXT-X-DISCONTINUITY")
+}
+
+// SetProgramDateTime 设置切片的 EXT-X-PROGRAM-DATE-TIME，零值表示移除。
+func (s *Segment) SetProgramDateTime(t time.Time) {
+	s.ProgramDateTime = t
+	if t.IsZero() {
+		s.removeTag("#EXT-X-PROGRAM-DATE-TIME")
+		return
+	}
+	value := t.Format("2006-01-02T15:04:05.000Z07:00")
+	if tag := s.tag("#EXT-X-PROGRAM-DATE-TIME"); tag != nil {
+		tag.Value, tag.colon = value, true
+		return
+	}
+	s.insertTag(NewTag("#EXT-X-PROGRAM-DATE-TIME", value), "#EXT-X-MAP", "#EXT-X-KEY", "#EXTINF")
+}
+
+// SetMap 在切片的 EXTINF 之前声明 EXT-X-MAP 初始化段，已声明时只替换 URI。
+func (s *Segment) SetMap(uri string) {
+	if tag := s.tag("#EXT-X-MAP"); tag != nil {
+		tag.SetAttr("URI", uri, true)
+		s.Map = tag
+		return
+	}
+	tag := NewTag("#EXT-X-MAP", "")
+	tag.SetAttr("URI", uri, true)
+	s.Map = tag
+	s.insertTag(tag, "#EXTINF")
+}
+
+// MapURI 返回对该切片生效的初始化段地址。
+func (s *Segment) MapURI() string {
+	if s.Map == nil {
+		return ""
+	}
+	uri, _ := s.Map.Attr("URI")
+	return uri
+}
+
+func (s *Segment) tag(name string) *Tag {
+	for _, tag := range s.Tags {
+		if strings.TrimSpace(tag.Name) == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// insertTag 将标签插入到首个名称属于 before 的标签之前，均不存在时追加到末尾。
+func (s *Segment) insertTag(tag *Tag, before ...string) {
+	i := len(s.Tags)
+	for j, t := range s.Tags {
+		if slices.Contains(before, strings.TrimSpace(t.Name)) {
+			i = j
+			break
+		}
+	}
+	s.Tags = slices.Insert(s.Tags, i, tag)
+}
+
+func (s *Segment) removeTag(name string) {
+	s.Tags = removeTag(s.Tags, name)
+}
+
+// removeTag 原地移除全部同名标签。
+func removeTag(tags []*Tag, name string) []*Tag {
+	kept := tags[:0]
+	for _, tag := range tags {
+		if strings.TrimSpace(tag.Name) != name {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// seconds2duration 将秒数转换为时长。
+func seconds2duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,102 @@
+package playlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	cases := []string{
+		"",
+		"\n",
+		"#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:3\n\n# comment\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key?a=1,b=2\",IV=0x01\n#EXTINF:2.000,title, with comma\n a.ts \n" +
+			"#EXT-X-CUSTOM:FOO=bar\n#EXTINF:2,\nb.ts\n#EXT-X-PART:DURATION=0.5,URI=\"p.m4s\"\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"q.m4s\"\n",
+		"#EXTM3U\r\n#EXT-X-STREAM-INF:BANDWIDTH=1000,CODECS=\"avc1,mp4a\"\r\nlow.m3u8\r\n",
+		"#EXTINF:1,\nseg.ts",
+	}
+	for _, content := range cases {
+		if got := Parse(content).String(); got != content {
+			t.Fatalf("round trip mismatch:\n%q\n%q", content, got)
+		}
+	}
+}
+
+func TestParseMediaPlaylist(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"100@0\"\n" +
+		"#EXTINF:1.5,\na.m4s\n#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:01.500Z\n" +
+		"#EXT-X-KEY:METHOD=NONE\n#EXTINF:2,\nb.m4s\n#EXT-X-ENDLIST\n"
+	pl := Parse(content)
+	if pl.MediaSequence != 7 || !pl.Ended || pl.Master || len(pl.Segments) != 2 || len(pl.Header) != 2 {
+		t.Fatalf("unexpected playlist: %+v", pl)
+	}
+	if seg := pl.Segments[0]; seg.URI != "a.m4s" || seg.Sequence != 7 || seg.Duration != 1500*time.Millisecond || seg.Discontinuity || seg.MapURI() != "init.mp4" {
+		t.Fatalf("unexpected first segment: %+v", seg)
+	}
+	seg := pl.Segments[1]
+	if !seg.Discontinuity || seg.Sequence != 8 || seg.MapURI() != "init.mp4" || seg.Key != nil {
+		t.Fatalf("unexpected second segment: %+v", seg)
+	}
+	if want := time.Date(2026, 1, 1, 0, 0, 1, 5e8, time.UTC); !seg.ProgramDateTime.Equal(want) {
+		t.Fatalf("unexpected program date time: %v", seg.ProgramDateTime)
+	}
+}
+
+func TestParseMasterPlaylist(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",URI=\"audio.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1280000,RESOLUTION=1280x720,CODECS=\"avc1.4d401f,mp4a.40.2\"\nhigh.m3u8\n"
+	pl := Parse(content)
+	if !pl.Master || len(pl.Segments) != 0 || len(pl.Variants) != 1 || len(pl.Renditions()) != 1 {
+		t.Fatalf("unexpected playlist: %+v", pl)
+	}
+	if v := pl.Variants[0]; v.URI != "high.m3u8" || v.Bandwidth != 1280000 || v.Resolution != "1280x720" || v.Codecs != "avc1.4d401f,mp4a.40.2" {
+		t.Fatalf("unexpected variant: %+v", v)
+	}
+}
+
+func TestEditPreservesOtherContent(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\" ,BYTERANGE=\"100@0\"\n#EXTINF:2.000,live\na.m4s\n#EXTINF:2,\nb.m4s\n"
+	pl := Parse(content)
+	pl.Segments[0].Map.SetAttr("URI", "/seg?payload=x", true)
+	pl.Segments[0].URI = "a2.m4s"
+	pl.Segments[1].SetDuration(1500 * time.Millisecond)
+	pl.Segments[1].SetDiscontinuity(true)
+
+	want := "#EXTM3U\n#EXT-X-MAP:URI=\"/seg?payload=x\",BYTERANGE=\"100@0\"\n#EXTINF:2.000,live\na2.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXTINF:1.500,\nb.m4s\n"
+	if got := pl.String(); got != want {
+		t.Fatalf("unexpected output:\n%q\n%q", got, want)
+	}
+
+	tag := NewTag("#EXT-X-DATERANGE", "")
+	tag.SetAttr("ID", "ad", true)
+	tag.SetAttr("DURATION", "10", false)
+	if got := tag.String(); got != `#EXT-X-DATERANGE:ID="ad",DURATION=10` {
+		t.Fatalf("unexpected tag: %s", got)
+	}
+}
+
+func TestBuildMediaPlaylist(t *testing.T) {
+	pl := NewMedia()
+	pl.SetVersion(6)
+	pl.SetTargetDuration(1500 * time.Millisecond)
+	pl.SetMediaSequence(5)
+	pl.SetDiscontinuitySequence(2)
+	first := pl.AppendSegment("5.m4s", 1500*time.Millisecond)
+	first.SetMap("init-1.mp4")
+	first.SetProgramDateTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	second := pl.AppendSegment("6.m4s", time.Second)
+	second.SetMap("init-2.mp4")
+	second.SetDiscontinuity(true)
+	pl.SetEnded(true)
+
+	want := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:5\n#EXT-X-DISCONTINUITY-SEQUENCE:2\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:00.000Z\n#EXT-X-MAP:URI=\"init-1.mp4\"\n#EXTINF:1.500,\n5.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init-2.mp4\"\n#EXTINF:1.000,\n6.m4s\n#EXT-X-ENDLIST\n"
+	if got := pl.String(); got != want {
+		t.Fatalf("unexpected output:\n%q\n%q", got, want)
+	}
+	if parsed := Parse(want); parsed.String() != want || parsed.Segments[1].Sequence != second.Sequence || parsed.Segments[1].MapURI() != "init-2.mp4" {
+		t.Fatalf("built playlist does not parse back: %+v", parsed)
+	}
+}
@@ -0,0 +1,152 @@
+package playlist
+
+import "strings"
+
+// Tag 为播放列表中的一行非 URI 内容：#EXT 标签按首个冒号拆分为 Name 与 Value，
+// 注释与空行整行保存在 Name 中。Value 保留原始字节，未识别的标签原样输出。
+type Tag struct {
+	Name  string
+	Value string
+	colon bool
+}
+
+// NewTag 创建标签，value 为空时输出不带冒号的标签名。
+func NewTag(name, value string) *Tag {
+	return &Tag{Name: name, Value: value, colon: value != ""}
+}
+
+// parseTag 解析一行非 URI 内容。
+func parseTag(line string) *Tag {
+	if strings.HasPrefix(line, "#EXT") {
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			return &Tag{Name: line[:i], Value: line[i+1:], colon: true}
+		}
+	}
+	return &Tag{Name: line}
+}
+
+// String 返回标签的文本形式。
+func (t *Tag) String() string {
+	if t.colon {
+		return t.Name + ":" + t.Value
+	}
+	return t.Name
+}
+
+// Attr 为属性列表中的一项，Value 已去除引号。
+type Attr struct {
+	Name   string
+	Value  string
+	Quoted bool
+}
+
+// Attrs 按属性列表解析 Value，属性名与值两侧的空白被去除。
+func (t *Tag) Attrs() []Attr {
+	pieces := splitAttrs(t.Value)
+	attrs := make([]Attr, 0, len(pieces))
+	for _, p := range pieces {
+		if !p.hasValue {
+			continue
+		}
+		value := strings.TrimSpace(p.raw)
+		quoted := len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"'
+		if quoted {
+			value = value[1 : len(value)-1]
+		}
+		attrs = append(attrs, Attr{Name: strings.TrimSpace(p.name), Value: value, Quoted: quoted})
+	}
+	return attrs
+}
+
+// Attr 返回指定属性去除引号后的值。
+func (t *Tag) Attr(name string) (string, bool) {
+	for _, a := range t.Attrs() {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr 设置属性值，quoted 为真时加引号；属性不存在时追加到末尾，其余属性的原始字节保持不变。
+func (t *Tag) SetAttr(name, value string, quoted bool) {
+	if quoted {
+		value = `"` + value + `"`
+	}
+	pieces := splitAttrs(t.Value)
+	found := false
+	for i, p := range pieces {
+		if p.hasValue && strings.TrimSpace(p.name) == name {
+			pieces[i].raw = value
+			found = true
+			break
+		}
+	}
+	if !found {
+		if len(pieces) == 1 && pieces[0].name == "" && !pieces[0].hasValue {
+			pieces = pieces[:0]
+		}
+		pieces = append(pieces, attrPiece{name: name, raw: value, hasValue: true})
+	}
+	parts := make([]string, len(pieces))
+	for i, p := range pieces {
+		parts[i] = p.String()
+	}
+	t.Value = strings.Join(parts, ",")
+	t.colon = true
+}
+
+// attrPiece 为属性列表中以逗号分隔的一段原始内容，以逗号重新拼接可还原原文。
+type attrPiece struct {
+	name     string
+	raw      string
+	hasValue bool
+}
+
+func (p attrPiece) String() string {
+	if p.hasValue {
+		return p.name + "=" + p.raw
+	}
+	return p.name
+}
+
+// splitAttrs 拆分属性列表，引号内的逗号不作为分隔符；不含等号的片段（如 EXTINF 的标题）原样保留。
+func splitAttrs(s string) []attrPiece {
+	var pieces []attrPiece
+	for {
+		eq := strings.IndexAny(s, "=,")
+		if eq < 0 || s[eq] == ',' {
+			end := len(s)
+			if eq >= 0 {
+				end = eq
+			}
+			pieces = append(pieces, attrPiece{name: s[:end]})
+			if eq < 0 {
+				return pieces
+			}
+			s = s[eq+1:]
+			continue
+		}
+
+		name, rest := s[:eq], s[eq+1:]
+		end := 0
+		if strings.HasPrefix(rest, `"`) {
+			if q := strings.IndexByte(rest[1:], '"'); q >= 0 {
+				end = q + 2
+			} else {
+				end = len(rest)
+			}
+		}
+		// 引号后直到下一个逗号的内容并入当前值，保证拼接后与原文一致。
+		if c := strings.IndexByte(rest[end:], ','); c >= 0 {
+			end += c
+		} else {
+			end = len(rest)
+		}
+		pieces = append(pieces, attrPiece{name: name, raw: rest[:end], hasValue: true})
+		if end == len(rest) {
+			return pieces
+		}
+		s = rest[end+1:]
+	}
+}
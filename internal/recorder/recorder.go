@@ -0,0 +1,192 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"PinkTide/internal/capture"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/trace"
+)
+
+// Status 查询房间是否直播中，并返回归一后的长号。
+type Status func(ctx context.Context, roomID string) (string, bool, error)
+
+// Playlist 返回房间最新的源播放列表。
+type Playlist func(ctx context.Context, roomID string) (*stream.Snapshot, error)
+
+// Fetch 下载切片或初始化段。
+type Fetch = capture.Fetch
+
+// Recorder 按开播状态自动录制配置的房间，每场直播写入独立目录。
+type Recorder struct {
+	dir      string
+	interval time.Duration
+	status   Status
+	playlist Playlist
+	fetch    Fetch
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New 创建录制器，interval 为开播状态检查间隔。
+func New(dir string, interval time.Duration, status Status, playlist Playlist, fetch Fetch, logger *slog.Logger) *Recorder {
+	ctx, cancel := context.WithCancel(trace.Background(context.Background()))
+	return &Recorder{
+		dir:      dir,
+		interval: interval,
+		status:   status,
+		playlist: playlist,
+		fetch:    fetch,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start 为每个房间启动开播状态监视。
+func (r *Recorder) Start(rooms []string) {
+	for _, roomID := range rooms {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.watch(roomID)
+		}()
+	}
+}
+
+// Close 停止全部录制并等待播放列表写入结束标记。
+func (r *Recorder) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// watch 定期检查开播状态，开播时启动录制会话，下播时结束会话；状态查询失败时保持现状。
+func (r *Recorder) watch(roomID string) {
+	var current *recording
+	defer func() { current.stop() }()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		// 会话因源站结束或出错退出后，下次检查仍在直播时重新开始。
+		if current.finished() {
+			current = nil
+		}
+		canonical, live, err := r.status(r.ctx, roomID)
+		switch {
+		case err != nil:
+			if r.logger != nil && r.ctx.Err() == nil {
+				r.logger.Warn("recording status check failed", "room_id", roomID, "error", err)
+			}
+		case live && current == nil:
+			current = r.begin(canonical)
+		case !live:
+			current.stop()
+			current = nil
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// begin 在后台启动录制会话。
+func (r *Recorder) begin(roomID string) *recording {
+	ctx, cancel := context.WithCancel(r.ctx)
+	rec := &recording{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(rec.done)
+		r.record(ctx, roomID)
+	}()
+	return rec
+}
+
+// recording 为运行中的录制会话句柄。
+type recording struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop 取消会话并等待播放列表写出。
+func (rec *recording) stop() {
+	if rec == nil {
+		return
+	}
+	rec.cancel()
+	<-rec.done
+}
+
+// finished 判断会话是否已自行结束。
+func (rec *recording) finished() bool {
+	if rec == nil {
+		return false
+	}
+	select {
+	case <-rec.done:
+		rec.cancel()
+		return true
+	default:
+		return false
+	}
+}
+
+// record 持续写入新切片直到 ctx 取消或源站播放列表结束，退出时写出点播播放列表。
+func (r *Recorder) record(ctx context.Context, roomID string) {
+	s, err := newSession(r.dir, roomID, time.Now(), r.fetch, r.logger)
+	if err != nil {
+		if r.logger != nil {
+			r.logger.Error("recording start failed", "room_id", roomID, "error", err)
+		}
+		return
+	}
+	if r.logger != nil {
+		r.logger.Info("recording started", "room_id", roomID, "dir", s.dir)
+	}
+	defer func() {
+		if err := s.finish(); err != nil && r.logger != nil {
+			r.logger.Error("recording finish failed", "room_id", roomID, "dir", s.dir, "error", err)
+		}
+		if r.logger != nil {
+			r.logger.Info("recording stopped", "room_id", roomID, "dir", s.dir, "segments", len(s.entries))
+		}
+	}()
+
+	var last *stream.Snapshot
+	ticker := time.NewTicker(capture.PollInterval)
+	defer ticker.Stop()
+	for {
+		snap, err := r.playlist(ctx, roomID)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil && r.logger != nil {
+				r.logger.Debug("recording playlist unavailable", "room_id", roomID, "error", err)
+			}
+		case snap != last:
+			last = snap
+			if err := s.update(ctx, snap); err != nil {
+				if errors.Is(err, errStreamEnded) || errors.Is(err, context.Canceled) {
+					return
+				}
+				if r.logger != nil {
+					r.logger.Error("recording stopped on error", "room_id", roomID, "dir", s.dir, "error", err)
+				}
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
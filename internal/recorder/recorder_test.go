@@ -0,0 +1,149 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PinkTide/internal/stream"
+)
+
+// mediaPlaylistContent 生成从 sequence 开始、含 count 个 2 秒切片的播放列表。
+func mediaPlaylistContent(sequence, count int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, "#EXTINF:2.000,\nseg%d.ts?token=a\n", sequence+i)
+	}
+	return b.String()
+}
+
+func fetchName(_ context.Context, target string) ([]byte, error) {
+	return []byte(target), nil
+}
+
+func TestSessionRecordsGapsAndResets(t *testing.T) {
+	s, err := newSession(t.TempDir(), "1001", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), fetchName, nil)
+	if err != nil {
+		t.Fatalf("new session failed: %v", err)
+	}
+	ctx := context.Background()
+	updates := []string{
+		mediaPlaylistContent(10, 3),
+		mediaPlaylistContent(11, 3),
+		mediaPlaylistContent(16, 3), // 14、15 已滑出窗口
+		mediaPlaylistContent(0, 2),  // 源站重启
+	}
+	for _, content := range updates {
+		snap := &stream.Snapshot{Content: content, OriginBase: "https://origin.example.com/live/index.m3u8"}
+		if err := s.update(ctx, snap); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	if filepath.Base(s.dir) != "20260102-030405" {
+		t.Fatalf("unexpected session dir: %s", s.dir)
+	}
+	if len(s.entries) != 9 {
+		t.Fatalf("expected 9 segments, got %d", len(s.entries))
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, "000003.ts"))
+	if err != nil || string(data) != "https://origin.example.com/live/seg13.ts?token=a" {
+		t.Fatalf("unexpected segment content %q: %v", data, err)
+	}
+	// 录制中逐个追加的播放列表与整体生成的结果一致。
+	live, err := os.ReadFile(filepath.Join(s.dir, playlistName))
+	if err != nil || string(live) != renderPlaylist(s.entries, false).String() {
+		t.Fatalf("unexpected live playlist:\n%s", live)
+	}
+
+	if err := s.finish(); err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+	playlist, err := os.ReadFile(filepath.Join(s.dir, playlistName))
+	if err != nil {
+		t.Fatalf("read playlist failed: %v", err)
+	}
+	text := string(playlist)
+	if !strings.Contains(text, "#EXT-X-PLAYLIST-TYPE:VOD\n") || !strings.HasSuffix(text, "000008.ts\n#EXT-X-ENDLIST\n") {
+		t.Fatalf("unexpected playlist:\n%s", text)
+	}
+	if n := strings.Count(text, "#EXT-X-DISCONTINUITY\n"); n != 2 {
+		t.Fatalf("expected 2 discontinuities, got %d:\n%s", n, text)
+	}
+	if !strings.Contains(text, "000003.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\n000004.ts\n") {
+		t.Fatalf("expected discontinuity at gap:\n%s", text)
+	}
+}
+
+func TestSessionWritesInitSegments(t *testing.T) {
+	s, err := newSession(t.TempDir(), "1001", time.Now(), fetchName, nil)
+	if err != nil {
+		t.Fatalf("new session failed: %v", err)
+	}
+	content := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"h1.mp4\"\n#EXTINF:1,\n1.m4s\n" +
+		"#EXT-X-MAP:URI=\"h2.mp4\"\n#EXTINF:1,\n2.m4s\n#EXTINF:1,\n3.m4s\n#EXTINF:3,\n4.m4s\n#EXT-X-ENDLIST\n"
+	err = s.update(context.Background(), &stream.Snapshot{Content: content, OriginBase: "https://origin.example.com/a/index.m3u8"})
+	if err != errStreamEnded {
+		t.Fatalf("expected stream end, got %v", err)
+	}
+	// 追加时初始化段变化处输出 EXT-X-MAP，更长的切片使头部目标时长随整体重写更新。
+	live, err := os.ReadFile(filepath.Join(s.dir, playlistName))
+	if err != nil || string(live) != renderPlaylist(s.entries, false).String() || !strings.Contains(string(live), "#EXT-X-TARGETDURATION:3\n") {
+		t.Fatalf("unexpected live playlist:\n%s", live)
+	}
+	got := renderPlaylist(s.entries, true).String()
+	want := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:3\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-MAP:URI=\"init-1.mp4\"\n#EXTINF:1.000,\n000000.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init-2.mp4\"\n#EXTINF:1.000,\n000001.m4s\n" +
+		"#EXTINF:1.000,\n000002.m4s\n#EXTINF:3.000,\n000003.m4s\n#EXT-X-ENDLIST\n"
+	if got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+	if data, err := os.ReadFile(filepath.Join(s.dir, "init-2.mp4")); err != nil || string(data) != "https://origin.example.com/a/h2.mp4" {
+		t.Fatalf("unexpected init segment %q: %v", data, err)
+	}
+}
+
+func TestRecorderFollowsLiveStatus(t *testing.T) {
+	dir := t.TempDir()
+	var live atomic.Bool
+	live.Store(true)
+	status := func(context.Context, string) (string, bool, error) { return "1001", live.Load(), nil }
+	snap := &stream.Snapshot{Content: mediaPlaylistContent(1, 2), OriginBase: "https://origin.example.com/index.m3u8"}
+	playlist := func(context.Context, string) (*stream.Snapshot, error) { return snap, nil }
+
+	rec := New(dir, 10*time.Millisecond, status, playlist, fetchName, nil)
+	defer rec.Close()
+	rec.Start([]string{"1"})
+
+	sessions := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "1001", "*", playlistName))
+		return matches
+	}
+	waitFor(t, func() bool { return len(sessions()) == 1 })
+	live.Store(false)
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(sessions()[0])
+		return strings.HasSuffix(string(data), "#EXT-X-ENDLIST\n")
+	})
+	data, _ := os.ReadFile(sessions()[0])
+	if strings.Count(string(data), "#EXTINF:") != 2 {
+		t.Fatalf("unexpected recording:\n%s", data)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,142 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"PinkTide/internal/capture"
+	"PinkTide/internal/playlist"
+	"PinkTide/internal/stream"
+)
+
+const playlistName = "index.m3u8"
+
+var errStreamEnded = errors.New("stream ended")
+
+// session 将一场直播的切片按顺序写入独立目录，并维护对应的点播播放列表。
+type session struct {
+	roomID  string
+	dir     string
+	logger  *slog.Logger
+	writer  *capture.Writer
+	entries []capture.Segment
+	// written 为播放列表文件中已有的切片数，target 与 version 为文件头部的目标时长与版本。
+	written int
+	target  time.Duration
+	version int
+}
+
+// newSession 在 root/roomID 下按开始时间创建会话目录，同一秒内的重复会话追加序号。
+func newSession(root, roomID string, start time.Time, fetch Fetch, logger *slog.Logger) (*session, error) {
+	parent := filepath.Join(root, roomID)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+	name := start.Format("20060102-150405")
+	dir := filepath.Join(parent, name)
+	for i := 1; ; i++ {
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		dir = filepath.Join(parent, fmt.Sprintf("%s-%d", name, i))
+	}
+	s := &session{roomID: roomID, dir: dir, logger: logger}
+	s.writer = capture.NewWriter(dir, "%06d", fetch, s.warn)
+	return s, nil
+}
+
+// update 写入播放列表中尚未录制的切片，每写入一个切片将其追加到播放列表。
+func (s *session) update(ctx context.Context, snap *stream.Snapshot) error {
+	pl, err := s.writer.Update(ctx, snap, func(seg capture.Segment) error {
+		s.entries = append(s.entries, seg)
+		return s.appendPlaylist()
+	})
+	if err != nil {
+		return err
+	}
+	if pl.Ended {
+		return errStreamEnded
+	}
+	return nil
+}
+
+// finish 将播放列表改写为带 EXT-X-ENDLIST 的点播列表，未录到切片时删除空目录。
+func (s *session) finish() error {
+	if len(s.entries) == 0 {
+		return os.RemoveAll(s.dir)
+	}
+	return s.writePlaylist(true)
+}
+
+// appendPlaylist 将新切片追加到录制中的 EVENT 播放列表末尾，避免每个切片重写整个文件；
+// 首个切片或头部的目标时长、版本需要调整时改为整体重写。
+func (s *session) appendPlaylist() error {
+	added := s.entries[s.written:]
+	rewrite := s.written == 0
+	for _, seg := range added {
+		if seg.Duration > s.target || (seg.MapFile != "" && s.version < 6) {
+			rewrite = true
+		}
+	}
+	if rewrite {
+		return s.writePlaylist(false)
+	}
+
+	// 连同上一个切片一起生成，使 EXT-X-MAP 只在初始化段变化时输出。
+	pl := capture.Playlist(s.entries[s.written-1:], "")
+	pl.Header = nil
+	pl.Segments = pl.Segments[1:]
+	f, err := os.OpenFile(filepath.Join(s.dir, playlistName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(pl.String()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.written = len(s.entries)
+	return nil
+}
+
+// writePlaylist 原子地写出播放列表，录制中为 EVENT 类型，结束后为 VOD 类型。
+func (s *session) writePlaylist(ended bool) error {
+	pl := renderPlaylist(s.entries, ended)
+	tmp := filepath.Join(s.dir, playlistName+".tmp")
+	if err := os.WriteFile(tmp, []byte(pl.String()), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, playlistName)); err != nil {
+		return err
+	}
+	s.written, s.target, s.version = len(s.entries), pl.TargetDuration, pl.Version
+	return nil
+}
+
+func (s *session) warn(msg string, args ...any) {
+	if s.logger != nil {
+		s.logger.Warn("recording "+msg, append([]any{"room_id", s.roomID, "dir", s.dir}, args...)...)
+	}
+}
+
+// renderPlaylist 生成录制播放列表，切片地址相对于播放列表所在目录。
+func renderPlaylist(entries []capture.Segment, ended bool) *playlist.Playlist {
+	playlistType := "EVENT"
+	if ended {
+		playlistType = "VOD"
+	}
+	pl := capture.Playlist(entries, playlistType)
+	pl.SetEnded(ended)
+	return pl
+}
@@ -0,0 +1,156 @@
+package remux
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrUnsupportedCodec 表示 FLV 中的编码无法封装为 MPEG-TS。
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	errInvalidConfig    = errors.New("invalid decoder config")
+)
+
+// startCode 为 Annex B 起始码。
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// accessUnitDelimiter 为每个访问单元前插入的 AUD。
+var accessUnitDelimiter = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0}
+
+// avcConfig 为 AVCDecoderConfigurationRecord 中的参数集。
+type avcConfig struct {
+	lengthSize int
+	sps        [][]byte
+	pps        [][]byte
+}
+
+// parseAVCConfig 解析 AVCDecoderConfigurationRecord。
+func parseAVCConfig(b []byte) (avcConfig, error) {
+	if len(b) < 6 || b[0] != 1 {
+		return avcConfig{}, errInvalidConfig
+	}
+	cfg := avcConfig{lengthSize: int(b[4]&0x03) + 1}
+	b = b[5:]
+	var err error
+	count := int(b[0] & 0x1f)
+	b = b[1:]
+	if cfg.sps, b, err = readParameterSets(b, count); err != nil {
+		return avcConfig{}, err
+	}
+	if len(b) < 1 {
+		return avcConfig{}, errInvalidConfig
+	}
+	count = int(b[0])
+	if cfg.pps, _, err = readParameterSets(b[1:], count); err != nil {
+		return avcConfig{}, err
+	}
+	return cfg, nil
+}
+
+// readParameterSets 读取以 2 字节长度前缀的参数集列表。
+func readParameterSets(b []byte, count int) ([][]byte, []byte, error) {
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < 2 {
+			return nil, nil, errInvalidConfig
+		}
+		size := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+size {
+			return nil, nil, errInvalidConfig
+		}
+		sets = append(sets, b[2:2+size])
+		b = b[2+size:]
+	}
+	return sets, b, nil
+}
+
+// annexB 将长度前缀的 NALU 转为带起始码的访问单元，关键帧前补充 SPS/PPS。
+func (c avcConfig) annexB(b []byte, keyframe bool) ([]byte, error) {
+	out := make([]byte, 0, len(b)+64)
+	out = append(out, accessUnitDelimiter...)
+	var nalus [][]byte
+	hasParams := false
+	for len(b) > 0 {
+		if len(b) < c.lengthSize {
+			return nil, errInvalidConfig
+		}
+		size := 0
+		for i := 0; i < c.lengthSize; i++ {
+			size = size<<8 | int(b[i])
+		}
+		b = b[c.lengthSize:]
+		if size > len(b) {
+			return nil, errInvalidConfig
+		}
+		nalu := b[:size]
+		b = b[size:]
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case 9:
+			continue
+		case 7, 8:
+			hasParams = true
+		}
+		nalus = append(nalus, nalu)
+	}
+	if keyframe && !hasParams {
+		for _, ps := range c.sps {
+			out = append(append(out, startCode...), ps...)
+		}
+		for _, ps := range c.pps {
+			out = append(append(out, startCode...), ps...)
+		}
+	}
+	for _, nalu := range nalus {
+		out = append(append(out, startCode...), nalu...)
+	}
+	return out, nil
+}
+
+// aacConfig 为 AudioSpecificConfig 中封装 ADTS 所需的字段。
+type aacConfig struct {
+	objectType int
+	freqIndex  int
+	channels   int
+	sampleRate int
+}
+
+// sampleRates 为 AAC 采样率索引表。
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// parseAACConfig 解析 AudioSpecificConfig。
+func parseAACConfig(b []byte) (aacConfig, error) {
+	if len(b) < 2 {
+		return aacConfig{}, errInvalidConfig
+	}
+	cfg := aacConfig{
+		objectType: int(b[0] >> 3),
+		freqIndex:  int(b[0]&0x07)<<1 | int(b[1]>>7),
+		channels:   int(b[1]>>3) & 0x0f,
+	}
+	if cfg.freqIndex >= len(sampleRates) {
+		return aacConfig{}, errInvalidConfig
+	}
+	cfg.sampleRate = sampleRates[cfg.freqIndex]
+	return cfg, nil
+}
+
+// adts 为原始 AAC 帧添加 ADTS 头；ADTS 只能表达 1~4 号对象类型，其余按 AAC-LC 标记。
+func (c aacConfig) adts(frame []byte) []byte {
+	profile := c.objectType - 1
+	if profile < 0 || profile > 3 {
+		profile = 1
+	}
+	size := 7 + len(frame)
+	out := make([]byte, 7, size)
+	out[0] = 0xff
+	out[1] = 0xf1
+	out[2] = byte(profile)<<6 | byte(c.freqIndex)<<2 | byte(c.channels>>2)&0x01
+	out[3] = byte(c.channels&0x03)<<6 | byte(size>>11)&0x03
+	out[4] = byte(size >> 3)
+	out[5] = byte(size&0x07)<<5 | 0x1f
+	out[6] = 0xfc
+	return append(out, frame...)
+}
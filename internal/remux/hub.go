@@ -0,0 +1,364 @@
+package remux
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"PinkTide/internal/flv"
+	"PinkTide/internal/playlist"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/trace"
+)
+
+// Scheme 为转封装切片的内部地址协议，/seg 据此从内存读取而非回源。
+const Scheme = "remux"
+
+const (
+	// segmentTarget 为切片目标时长，实际在其后的首个关键帧处切分。
+	segmentTarget = 2 * time.Second
+	// windowSize 为播放列表中保留的切片数。
+	windowSize = 6
+	// retainSize 为内存中保留的切片数，多于窗口以照顾落后的播放器。
+	retainSize = windowSize + 4
+	// firstSegmentTimeout 为等待首个切片的最长时间。
+	firstSegmentTimeout = 10 * time.Second
+	// codecRetryDelay 为会话因编码不受支持结束后重新拉流前的等待时间，期间的请求直接返回该错误。
+	codecRetryDelay = time.Minute
+)
+
+// ErrNotReady 表示转封装会话尚未产出切片。
+var ErrNotReady = errors.New("remux not ready")
+
+// Hub 为仅提供 FLV 的房间维护转封装会话，共享 FLV 分发器的上游连接并输出滑动窗口 HLS。
+type Hub struct {
+	flv         *flv.Hub
+	idleTimeout time.Duration
+	logger      *slog.Logger
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	sessions    map[string]*session
+}
+
+// NewHub 创建转封装会话集合，会话在 idleTimeout 内无播放列表访问时停止。
+func NewHub(flvHub *flv.Hub, idleTimeout time.Duration, logger *slog.Logger) *Hub {
+	ctx, cancel := context.WithCancel(trace.Background(context.Background()))
+	h := &Hub{
+		flv:         flvHub,
+		idleTimeout: idleTimeout,
+		logger:      logger,
+		ctx:         ctx,
+		cancel:      cancel,
+		sessions:    make(map[string]*session),
+	}
+	go h.janitor()
+	return h
+}
+
+// Snapshot 返回 key 对应会话的最新播放列表，首次访问时启动会话并等待首个切片。
+func (h *Hub) Snapshot(ctx context.Context, key string, source stream.Source) (*stream.Snapshot, error) {
+	s := h.acquire(key, source)
+	timer := time.NewTimer(firstSegmentTimeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		snap, done, err, updated := s.snapshot, s.done, s.err, s.updated
+		s.mu.Unlock()
+		if snap != nil {
+			return snap, nil
+		}
+		if done {
+			if err == nil {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil, ErrNotReady
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Segment 按内部地址读取切片，会话已结束或切片滑出保留范围时返回 false。
+func (h *Hub) Segment(target string) ([]byte, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != Scheme {
+		return nil, false
+	}
+	gen, name, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if !ok {
+		return nil, false
+	}
+	seq, err := strconv.ParseInt(strings.TrimSuffix(name, ".ts"), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	h.mu.Lock()
+	s, ok := h.sessions[u.Host]
+	h.mu.Unlock()
+	if !ok || s.gen != gen {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segments {
+		if seg.Sequence == seq {
+			return seg.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Close 停止全部会话。
+func (h *Hub) Close() {
+	h.cancel()
+}
+
+// acquire 获取或创建会话并刷新访问时间，已结束的会话由接续其切片序号的新会话替换；
+// 因编码不受支持结束的会话在 codecRetryDelay 内保留，避免每个请求都重新拉流。
+func (h *Hub) acquire(key string, source stream.Source) *session {
+	id := sessionID(key)
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	if ok {
+		s.mu.Lock()
+		ok = !s.done || s.unsupportedLocked(now)
+		s.mu.Unlock()
+	}
+	if !ok {
+		ctx, cancel := context.WithCancel(h.ctx)
+		next := &session{
+			id:       id,
+			key:      key,
+			gen:      strconv.FormatInt(time.Now().UnixNano(), 36),
+			cancel:   cancel,
+			updated:  make(chan struct{}),
+			accessed: time.Now(),
+		}
+		if s != nil {
+			next.resume(s)
+		}
+		s = next
+		h.sessions[id] = s
+		go h.run(ctx, s, source)
+	}
+	s.mu.Lock()
+	s.accessed = time.Now()
+	s.mu.Unlock()
+	return s
+}
+
+// run 订阅 FLV 上游并持续转封装，结束时记录原因。
+func (h *Hub) run(ctx context.Context, s *session, source stream.Source) {
+	if h.logger != nil {
+		h.logger.Debug("remux session started", "key", s.key)
+	}
+	err := h.remux(ctx, s, source)
+	if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if h.logger != nil {
+		if err != nil {
+			h.logger.Warn("remux session stopped", "key", s.key, "error", err)
+		} else {
+			h.logger.Debug("remux session stopped", "key", s.key)
+		}
+	}
+	s.mu.Lock()
+	s.done = true
+	s.err = err
+	s.ended = time.Now()
+	close(s.updated)
+	s.updated = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// remux 读取 FLV 标签并交给切片器，切片完成后更新播放列表。
+func (h *Hub) remux(ctx context.Context, s *session, source stream.Source) error {
+	viewer, err := h.flv.Subscribe(ctx, "flv:"+s.key, source)
+	if err != nil {
+		return err
+	}
+	defer viewer.Close()
+
+	reader := &viewerReader{ctx: ctx, viewer: viewer}
+	if _, err := flv.ReadHeader(reader); err != nil {
+		return err
+	}
+	seg := newSegmenter(segmentTarget, s.sequence, s.publish)
+	for {
+		tag, err := flv.ReadTag(reader)
+		if err != nil {
+			return err
+		}
+		if err := seg.push(tag); err != nil {
+			return err
+		}
+	}
+}
+
+// janitor 定期停止空闲会话。
+func (h *Hub) janitor() {
+	interval := h.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.evictIdle(time.Now())
+		}
+	}
+}
+
+// evictIdle 停止超过空闲时间的会话；已结束的会话保留至空闲，供替换它的新会话接续切片序号。
+func (h *Hub) evictIdle(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		s.mu.Lock()
+		idle := now.Sub(s.accessed) >= h.idleTimeout
+		s.mu.Unlock()
+		if !idle {
+			continue
+		}
+		s.cancel()
+		delete(h.sessions, id)
+	}
+}
+
+// sessionID 将会话键转换为可用作 URL 主机名的标识。
+func sessionID(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// session 保存一个房间的转封装切片与播放列表。
+type session struct {
+	id     string
+	key    string
+	gen    string
+	cancel context.CancelFunc
+	// sequence 为首个切片的序号，discontinuity 表示首个切片接续已结束的会话。
+	sequence      int64
+	discontinuity bool
+
+	mu              sync.Mutex
+	segments        []Segment
+	discontinuities int64
+	snapshot        *stream.Snapshot
+	done            bool
+	err             error
+	ended           time.Time
+	accessed        time.Time
+	updated         chan struct{}
+}
+
+// resume 接续已结束会话的代、切片序号与保留切片，新会话的首个切片标记为不连续，
+// 使正在播放的客户端看到递增的 EXT-X-MEDIA-SEQUENCE 而非回退。
+func (s *session) resume(prev *session) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	if len(prev.segments) == 0 {
+		return
+	}
+	s.gen = prev.gen
+	s.segments = append([]Segment(nil), prev.segments...)
+	s.discontinuities = prev.discontinuities
+	s.sequence = prev.segments[len(prev.segments)-1].Sequence + 1
+	s.discontinuity = true
+}
+
+// unsupportedLocked 判断会话是否因编码不受支持结束且未超过 codecRetryDelay，调用方需持有锁。
+func (s *session) unsupportedLocked(now time.Time) bool {
+	return s.done && errors.Is(s.err, ErrUnsupportedCodec) && now.Sub(s.ended) < codecRetryDelay
+}
+
+// publish 追加切片并重新生成播放列表，滑出窗口的不连续点计入 EXT-X-DISCONTINUITY-SEQUENCE。
+func (s *session) publish(seg Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.discontinuity {
+		seg.Discontinuity = true
+		s.discontinuity = false
+	}
+	s.segments = append(s.segments, seg)
+	if out := len(s.segments) - windowSize - 1; out >= 0 && s.segments[out].Discontinuity {
+		s.discontinuities++
+	}
+	if len(s.segments) > retainSize {
+		s.segments = append(s.segments[:0:0], s.segments[len(s.segments)-retainSize:]...)
+	}
+	window := s.segments
+	if len(window) > windowSize {
+		window = window[len(window)-windowSize:]
+	}
+	content := renderPlaylist(window, s.discontinuities)
+	s.snapshot = &stream.Snapshot{
+		Content:        content,
+		OriginBase:     fmt.Sprintf("%s://%s/%s/index.m3u8", Scheme, s.id, s.gen),
+		FetchedAt:      time.Now(),
+		TargetDuration: segmentTarget,
+	}
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// renderPlaylist 生成滑动窗口媒体播放列表，切片地址相对于播放列表。
+func renderPlaylist(segments []Segment, discontinuities int64) string {
+	pl := playlist.NewMedia()
+	pl.SetVersion(3)
+	var target time.Duration
+	for _, seg := range segments {
+		target = max(target, seg.Duration)
+	}
+	pl.SetTargetDuration(target)
+	pl.SetMediaSequence(segments[0].Sequence)
+	pl.SetDiscontinuitySequence(discontinuities)
+	for _, seg := range segments {
+		out := pl.AppendSegment(strconv.FormatInt(seg.Sequence, 10)+".ts", seg.Duration)
+		out.SetDiscontinuity(seg.Discontinuity)
+	}
+	return pl.String()
+}
+
+// viewerReader 将 FLV 观众的数据块适配为 io.Reader。
+type viewerReader struct {
+	ctx    context.Context
+	viewer *flv.Viewer
+	buf    []byte
+}
+
+func (r *viewerReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.viewer.Next(r.ctx)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
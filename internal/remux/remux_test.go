@@ -0,0 +1,228 @@
+package remux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"PinkTide/internal/flv"
+	"PinkTide/internal/origin"
+)
+
+var (
+	avcRecord = []byte{1, 0x64, 0, 0x1f, 0xff, 0xe1, 0, 4, 0x67, 1, 2, 3, 1, 0, 2, 0x68, 4}
+	aacRecord = []byte{0x12, 0x10}
+)
+
+// testTags 生成 seconds 秒的 H.264/AAC 标签，每秒一个关键帧、25 帧视频。
+func testTags(seconds int) []flv.Tag {
+	tags := []flv.Tag{
+		{Type: flv.TagScript, Data: []byte("onMetaData")},
+		{Type: flv.TagVideo, Data: append([]byte{0x17, 0, 0, 0, 0}, avcRecord...)},
+		{Type: flv.TagAudio, Data: append([]byte{0xaf, 0}, aacRecord...)},
+	}
+	for i := 0; i < seconds*25; i++ {
+		ts := uint32(i * 40)
+		if i%25 == 0 {
+			tags = append(tags, flv.Tag{Type: flv.TagVideo, Timestamp: ts, Data: []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}})
+		} else {
+			tags = append(tags, flv.Tag{Type: flv.TagVideo, Timestamp: ts, Data: []byte{0x27, 1, 0, 0, 40, 0, 0, 0, 2, 0x41, 0x9a}})
+		}
+		tags = append(tags, flv.Tag{Type: flv.TagAudio, Timestamp: ts, Data: []byte{0xaf, 1, 0x21, 0x10}})
+	}
+	return tags
+}
+
+func TestSegmenterCutsAtKeyframes(t *testing.T) {
+	var segments []Segment
+	seg := newSegmenter(2*time.Second, 0, func(s Segment) { segments = append(segments, s) })
+	for _, tag := range testTags(7) {
+		if err := seg.push(tag); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 complete segments, got %d", len(segments))
+	}
+	for i, s := range segments {
+		if s.Sequence != int64(i) || s.Duration != 2*time.Second {
+			t.Fatalf("unexpected segment %d: seq=%d duration=%s", i, s.Sequence, s.Duration)
+		}
+		if len(s.Data)%packetSize != 0 {
+			t.Fatalf("segment %d is not packet aligned", i)
+		}
+		for off := 0; off < len(s.Data); off += packetSize {
+			if s.Data[off] != 0x47 {
+				t.Fatalf("missing sync byte at %d", off)
+			}
+		}
+		// 每个切片以 PAT、PMT 开头，随后是带随机访问标记的视频包。
+		if pid := int(s.Data[1]&0x1f)<<8 | int(s.Data[2]); pid != 0 {
+			t.Fatalf("segment %d does not start with PAT", i)
+		}
+		if pid := int(s.Data[packetSize+1]&0x1f)<<8 | int(s.Data[packetSize+2]); pid != pmtPID {
+			t.Fatalf("segment %d missing PMT", i)
+		}
+		video := s.Data[2*packetSize:]
+		if pid := int(video[1]&0x1f)<<8 | int(video[2]); pid != videoPID || video[5]&0x40 == 0 {
+			t.Fatalf("segment %d does not start with a keyframe", i)
+		}
+		if !bytes.Contains(s.Data, []byte{0, 0, 0, 1, 0x67, 1, 2, 3, 0, 0, 0, 1, 0x68, 4}) {
+			t.Fatalf("segment %d missing SPS/PPS", i)
+		}
+	}
+
+	hevc := flv.Tag{Type: flv.TagVideo, Data: []byte{0x1c, 1, 0, 0, 0}}
+	if err := seg.push(hevc); err == nil {
+		t.Fatalf("expected hevc to be rejected")
+	}
+}
+
+func TestSegmenterFlushesOnTimestampReset(t *testing.T) {
+	var segments []Segment
+	seg := newSegmenter(2*time.Second, 0, func(s Segment) { segments = append(segments, s) })
+	// 3 秒后上游重置，时间戳从 0 重新开始。
+	tags := append(testTags(3), testTags(3)[3:]...)
+	for _, tag := range tags {
+		if err := seg.push(tag); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 complete segments, got %d", len(segments))
+	}
+	want := []struct {
+		duration      time.Duration
+		discontinuity bool
+	}{{2 * time.Second, false}, {time.Second, false}, {2 * time.Second, true}}
+	for i, s := range segments {
+		if s.Sequence != int64(i) || s.Duration != want[i].duration || s.Discontinuity != want[i].discontinuity || len(s.Data) == 0 {
+			t.Fatalf("unexpected segment %d: seq=%d duration=%s discontinuity=%v", i, s.Sequence, s.Duration, s.Discontinuity)
+		}
+	}
+}
+
+func TestTimestampEncoding(t *testing.T) {
+	const ts = int64(0x1_2345_6789)
+	b := encodeTimestamp(0x2, ts)
+	got := int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+	if got != ts&0x1ffffffff || b[0]>>4 != 0x2 || b[0]&1 != 1 || b[2]&1 != 1 || b[4]&1 != 1 {
+		t.Fatalf("unexpected timestamp encoding: %x", b)
+	}
+	// 标准 PAT（节目 1、PMT PID 0x1000）的 CRC。
+	if pat := patSection(); !bytes.Equal(pat[len(pat)-4:], []byte{0x2a, 0xb1, 0x04, 0xb2}) {
+		t.Fatalf("unexpected PAT crc: %x", pat[len(pat)-4:])
+	}
+}
+
+func TestHubServesRemuxedPlaylist(t *testing.T) {
+	header := []byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0}
+	var flvHub *flv.Hub
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(header)
+		w.(http.Flusher).Flush()
+		// 等待转封装会话订阅后再发送标签，避免前几个 GOP 只进入起播缓存。
+		for flvHub.Viewers("flv:room") == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		for _, tag := range testTags(5) {
+			_, _ = w.Write(tag.Bytes())
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	flvHub = flv.NewHub(origin.NewClient(time.Second, nil).Streaming(), nil)
+	defer flvHub.Close()
+	hub := NewHub(flvHub, time.Minute, nil)
+	defer hub.Close()
+	source := func(context.Context) (string, error) { return srv.URL + "/live.flv", nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	snap, err := hub.Snapshot(ctx, "room", source)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if !strings.HasPrefix(snap.OriginBase, Scheme+"://") || !strings.Contains(snap.Content, "#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:2.000,\n0.ts\n") {
+		t.Fatalf("unexpected playlist %s:\n%s", snap.OriginBase, snap.Content)
+	}
+
+	target := strings.TrimSuffix(snap.OriginBase, "index.m3u8") + "0.ts"
+	data, ok := hub.Segment(target)
+	if !ok || len(data) == 0 || data[0] != 0x47 {
+		t.Fatalf("expected remuxed segment for %s", target)
+	}
+	if _, ok := hub.Segment(strings.TrimSuffix(snap.OriginBase, "index.m3u8") + "99.ts"); ok {
+		t.Fatalf("expected unknown segment to be missing")
+	}
+	if n := flvHub.Viewers("flv:room"); n != 1 {
+		t.Fatalf("expected remux to share the flv relay, got %d viewers", n)
+	}
+}
+
+func TestHubCachesUnsupportedCodec(t *testing.T) {
+	// flv 为空：若重新拉流会直接 panic。
+	hub := &Hub{sessions: make(map[string]*session)}
+	ended := &session{
+		id:      sessionID("room"),
+		key:     "room",
+		done:    true,
+		err:     fmt.Errorf("%w: video codec 12", ErrUnsupportedCodec),
+		ended:   time.Now(),
+		updated: make(chan struct{}),
+	}
+	hub.sessions[ended.id] = ended
+	source := func(context.Context) (string, error) { return "", errors.New("unexpected redial") }
+
+	for i := 0; i < 3; i++ {
+		if _, err := hub.Snapshot(context.Background(), "room", source); !errors.Is(err, ErrUnsupportedCodec) {
+			t.Fatalf("expected cached ErrUnsupportedCodec, got %v", err)
+		}
+	}
+	if hub.sessions[ended.id] != ended {
+		t.Fatalf("expected ended session to be kept")
+	}
+	if ended.unsupportedLocked(ended.ended.Add(codecRetryDelay)) {
+		t.Fatalf("expected codec error to expire after %s", codecRetryDelay)
+	}
+}
+
+func TestSessionResumesSequence(t *testing.T) {
+	prev := &session{id: "a", gen: "g1", updated: make(chan struct{})}
+	for i := int64(0); i < 3; i++ {
+		prev.publish(Segment{Sequence: i, Duration: 2 * time.Second})
+	}
+
+	next := &session{id: "a", gen: "g2", updated: make(chan struct{})}
+	next.resume(prev)
+	if next.gen != "g1" || next.sequence != 3 {
+		t.Fatalf("expected to resume generation g1 at 3, got %s at %d", next.gen, next.sequence)
+	}
+	seg := newSegmenter(2*time.Second, next.sequence, next.publish)
+	for _, tag := range testTags(3) {
+		if err := seg.push(tag); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+	want := "#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:2.000,\n0.ts\n#EXTINF:2.000,\n1.ts\n#EXTINF:2.000,\n2.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\n3.ts\n"
+	if !strings.Contains(next.snapshot.Content, want) {
+		t.Fatalf("expected discontinuity after resumed segments:\n%s", next.snapshot.Content)
+	}
+
+	// 不连续点滑出窗口后计入 EXT-X-DISCONTINUITY-SEQUENCE。
+	for i := int64(4); i < 4+windowSize; i++ {
+		next.publish(Segment{Sequence: i, Duration: 2 * time.Second})
+	}
+	if !strings.Contains(next.snapshot.Content, "#EXT-X-MEDIA-SEQUENCE:4\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n") ||
+		strings.Contains(next.snapshot.Content, "#EXT-X-DISCONTINUITY\n") {
+		t.Fatalf("unexpected playlist after discontinuity left the window:\n%s", next.snapshot.Content)
+	}
+}
@@ -0,0 +1,172 @@
+package remux
+
+import (
+	"fmt"
+	"time"
+
+	"PinkTide/internal/flv"
+)
+
+// Segment 为一个已完成的 MPEG-TS 切片，Discontinuity 表示其与前一切片不连续。
+type Segment struct {
+	Sequence      int64
+	Duration      time.Duration
+	Data          []byte
+	Discontinuity bool
+}
+
+// segmenter 将 FLV 标签转封装为 MPEG-TS，并在达到目标时长后的首个关键帧处切分。
+type segmenter struct {
+	target time.Duration
+	emit   func(Segment)
+
+	video    *avcConfig
+	audio    *aacConfig
+	writer   *tsWriter
+	sequence int64
+	startDTS int64
+	started  bool
+	// lastDTS 为当前切片最后一帧的时间戳，step 为最近的帧间隔，时间戳回退时据此结束切片。
+	lastDTS int64
+	step    int64
+	// discontinuity 表示下一个输出的切片与前一切片时间戳不连续。
+	discontinuity bool
+}
+
+// newSegmenter 创建切片器，首个切片序号为 sequence。
+func newSegmenter(target time.Duration, sequence int64, emit func(Segment)) *segmenter {
+	return &segmenter{target: target, sequence: sequence, emit: emit}
+}
+
+// push 处理一个 FLV 标签，非 H.264/AAC 的音视频标签返回 ErrUnsupportedCodec。
+func (s *segmenter) push(tag flv.Tag) error {
+	switch tag.Type {
+	case flv.TagVideo:
+		return s.pushVideo(tag)
+	case flv.TagAudio:
+		return s.pushAudio(tag)
+	}
+	return nil
+}
+
+func (s *segmenter) pushVideo(tag flv.Tag) error {
+	if len(tag.Data) < 5 {
+		return nil
+	}
+	if tag.Data[0]&0x80 != 0 || tag.Data[0]&0x0f != 7 {
+		return fmt.Errorf("%w: video codec %d", ErrUnsupportedCodec, tag.Data[0]&0x0f)
+	}
+	switch tag.Data[1] {
+	case 0:
+		cfg, err := parseAVCConfig(tag.Data[5:])
+		if err != nil {
+			return err
+		}
+		s.video = &cfg
+		return nil
+	case 1:
+	default:
+		return nil
+	}
+	if s.video == nil {
+		return nil
+	}
+
+	keyframe := tag.IsKeyframe()
+	cts := int64(int32(uint32(tag.Data[2])<<16|uint32(tag.Data[3])<<8|uint32(tag.Data[4])) << 8 >> 8)
+	dts := int64(tag.Timestamp) * 90
+	pts := dts + cts*90
+	if !s.started && !keyframe {
+		return nil
+	}
+	if keyframe {
+		s.cut(dts)
+	}
+	au, err := s.video.annexB(tag.Data[5:], keyframe)
+	if err != nil {
+		return err
+	}
+	s.writer.writeVideo(au, pts, dts, keyframe)
+	s.advance(dts)
+	return nil
+}
+
+func (s *segmenter) pushAudio(tag flv.Tag) error {
+	if len(tag.Data) < 2 {
+		return nil
+	}
+	if tag.Data[0]>>4 != 10 {
+		return fmt.Errorf("%w: audio format %d", ErrUnsupportedCodec, tag.Data[0]>>4)
+	}
+	if tag.Data[1] == 0 {
+		cfg, err := parseAACConfig(tag.Data[2:])
+		if err != nil {
+			return err
+		}
+		s.audio = &cfg
+		return nil
+	}
+	if s.audio == nil {
+		return nil
+	}
+
+	pts := int64(tag.Timestamp) * 90
+	if s.video == nil {
+		// 纯音频流没有关键帧，按音频时间切分。
+		s.cut(pts)
+	}
+	if !s.started {
+		return nil
+	}
+	s.writer.writeAudio(s.audio.adts(tag.Data[2:]), pts)
+	if s.video == nil {
+		s.advance(pts)
+	}
+	return nil
+}
+
+// cut 在可切分位置检查当前切片时长，达到目标后输出切片并开始新切片。
+func (s *segmenter) cut(dts int64) {
+	// 时间戳回退视为上游重置：按已写入的帧结束当前切片并立即切分，下一切片标记为不连续。
+	reset := s.started && dts < s.lastDTS
+	if s.started && !reset && dts-s.startDTS < s.target.Milliseconds()*90 {
+		return
+	}
+	if reset {
+		s.flush(s.lastDTS + s.step)
+		s.discontinuity = true
+	} else {
+		s.flush(dts)
+	}
+	if s.writer == nil {
+		s.writer = newTSWriter(s.video != nil, s.audio != nil)
+	}
+	s.writer.hasVideo, s.writer.hasAudio = s.video != nil, s.audio != nil
+	s.writer.reset()
+	s.startDTS = dts
+	s.lastDTS = dts
+	s.started = true
+}
+
+// advance 记录当前切片最后一帧的时间戳与帧间隔。
+func (s *segmenter) advance(dts int64) {
+	if dts > s.lastDTS {
+		s.step = dts - s.lastDTS
+		s.lastDTS = dts
+	}
+}
+
+// flush 输出当前切片，end 为切片结束时刻，用于计算时长。
+func (s *segmenter) flush(end int64) {
+	if !s.started || end <= s.startDTS {
+		return
+	}
+	s.emit(Segment{
+		Sequence:      s.sequence,
+		Duration:      time.Duration((end - s.startDTS) * int64(time.Second) / 90000),
+		Data:          s.writer.bytes(),
+		Discontinuity: s.discontinuity,
+	})
+	s.discontinuity = false
+	s.sequence++
+}
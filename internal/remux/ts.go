@@ -0,0 +1,233 @@
+package remux
+
+import "bytes"
+
+const (
+	packetSize = 188
+	pmtPID     = 0x1000
+	videoPID   = 0x100
+	audioPID   = 0x101
+
+	streamTypeH264 = 0x1b
+	streamTypeAAC  = 0x0f
+)
+
+// tsWriter 将 PES 封装为 MPEG-TS 包，并维护各 PID 的连续计数。
+type tsWriter struct {
+	buf        bytes.Buffer
+	hasVideo   bool
+	hasAudio   bool
+	continuity map[uint16]byte
+}
+
+func newTSWriter(hasVideo, hasAudio bool) *tsWriter {
+	return &tsWriter{hasVideo: hasVideo, hasAudio: hasAudio, continuity: make(map[uint16]byte)}
+}
+
+// reset 清空已写出的数据并写入 PAT/PMT，用于开始新切片；连续计数跨切片保持递增。
+func (w *tsWriter) reset() {
+	w.buf.Reset()
+	w.writePSI(0, patSection())
+	w.writePSI(pmtPID, pmtSection(w.hasVideo, w.hasAudio))
+}
+
+// bytes 返回当前切片数据的副本。
+func (w *tsWriter) bytes() []byte {
+	return bytes.Clone(w.buf.Bytes())
+}
+
+// writeVideo 写入一帧 H.264 访问单元，关键帧携带随机访问标记与 PCR。
+func (w *tsWriter) writeVideo(au []byte, pts, dts int64, keyframe bool) {
+	pes := pesPacket(0xe0, au, pts, dts, false)
+	w.writePES(videoPID, pes, dts, keyframe)
+}
+
+// writeAudio 写入一帧 ADTS 音频；纯音频流时由音频 PID 携带 PCR。
+func (w *tsWriter) writeAudio(frame []byte, pts int64) {
+	pes := pesPacket(0xc0, frame, pts, pts, true)
+	pcr := int64(-1)
+	if !w.hasVideo {
+		pcr = pts
+	}
+	w.writePES(audioPID, pes, pcr, false)
+}
+
+// writePES 将 PES 切分为 TS 包，不足一包时以自适应字段填充。
+func (w *tsWriter) writePES(pid uint16, pes []byte, pcr int64, randomAccess bool) {
+	first := true
+	for len(pes) > 0 {
+		var pkt [packetSize]byte
+		pkt[0] = 0x47
+		pkt[1] = byte(pid>>8) & 0x1f
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+
+		var af []byte
+		hasAF := false
+		if first && (pcr >= 0 || randomAccess) {
+			hasAF = true
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pcr >= 0 {
+				flags |= 0x10
+			}
+			af = append(af, flags)
+			if pcr >= 0 {
+				af = append(af, encodePCR(pcr)...)
+			}
+		}
+		space := packetSize - 4
+		if hasAF {
+			space -= 1 + len(af)
+		}
+		if len(pes) < space {
+			stuffing := space - len(pes)
+			if hasAF {
+				af = append(af, bytes.Repeat([]byte{0xff}, stuffing)...)
+			} else {
+				hasAF = true
+				if stuffing > 1 {
+					af = append([]byte{0x00}, bytes.Repeat([]byte{0xff}, stuffing-2)...)
+				}
+			}
+			space = len(pes)
+		}
+
+		cc := w.continuity[pid]
+		w.continuity[pid] = (cc + 1) & 0x0f
+		off := 4
+		if hasAF {
+			pkt[3] = 0x30 | cc
+			pkt[4] = byte(len(af))
+			copy(pkt[5:], af)
+			off = 5 + len(af)
+		} else {
+			pkt[3] = 0x10 | cc
+		}
+		copy(pkt[off:], pes[:space])
+		pes = pes[space:]
+		first = false
+		w.buf.Write(pkt[:])
+	}
+}
+
+// writePSI 写入单包 PSI 表，剩余空间以 0xFF 填充。
+func (w *tsWriter) writePSI(pid uint16, section []byte) {
+	var pkt [packetSize]byte
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+	cc := w.continuity[pid]
+	w.continuity[pid] = (cc + 1) & 0x0f
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid>>8)&0x1f
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | cc
+	pkt[4] = 0x00
+	copy(pkt[5:], section)
+	w.buf.Write(pkt[:])
+}
+
+// patSection 生成只含一个节目的 PAT。
+func patSection() []byte {
+	section := []byte{
+		0x00, 0xb0, 13,
+		0x00, 0x01, 0xc1, 0x00, 0x00,
+		0x00, 0x01, 0xe0 | byte(pmtPID>>8), byte(pmtPID & 0xff),
+	}
+	return appendCRC(section)
+}
+
+// pmtSection 生成包含视频与音频流的 PMT，PCR 由视频 PID 携带，纯音频时由音频 PID 携带。
+func pmtSection(hasVideo, hasAudio bool) []byte {
+	pcrPID := uint16(videoPID)
+	if !hasVideo {
+		pcrPID = audioPID
+	}
+	var streams []byte
+	if hasVideo {
+		streams = append(streams, streamTypeH264, 0xe0|byte(videoPID>>8), byte(videoPID&0xff), 0xf0, 0x00)
+	}
+	if hasAudio {
+		streams = append(streams, streamTypeAAC, 0xe0|byte(audioPID>>8), byte(audioPID&0xff), 0xf0, 0x00)
+	}
+	length := 9 + len(streams) + 4
+	section := []byte{
+		0x02, 0xb0 | byte(length>>8), byte(length),
+		0x00, 0x01, 0xc1, 0x00, 0x00,
+		0xe0 | byte(pcrPID>>8), byte(pcrPID & 0xff),
+		0xf0, 0x00,
+	}
+	section = append(section, streams...)
+	return appendCRC(section)
+}
+
+// pesPacket 生成 PES 包头与负载，DTS 与 PTS 相同时只写 PTS；音频写入准确长度，视频长度置 0。
+func pesPacket(streamID byte, payload []byte, pts, dts int64, bounded bool) []byte {
+	withDTS := dts != pts
+	headerData := 5
+	flags := byte(0x80)
+	if withDTS {
+		headerData = 10
+		flags = 0xc0
+	}
+	pes := make([]byte, 0, 9+headerData+len(payload))
+	pes = append(pes, 0x00, 0x00, 0x01, streamID)
+	length := 3 + headerData + len(payload)
+	if !bounded || length > 0xffff {
+		length = 0
+	}
+	pes = append(pes, byte(length>>8), byte(length), 0x80, flags, byte(headerData))
+	if withDTS {
+		pes = append(pes, encodeTimestamp(0x3, pts)...)
+		pes = append(pes, encodeTimestamp(0x1, dts)...)
+	} else {
+		pes = append(pes, encodeTimestamp(0x2, pts)...)
+	}
+	return append(pes, payload...)
+}
+
+// encodeTimestamp 按 PES 格式编码 33 位时间戳。
+func encodeTimestamp(prefix byte, ts int64) []byte {
+	ts &= 0x1ffffffff
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0e | 0x01,
+		byte(ts >> 22),
+		byte(ts>>14)&0xfe | 0x01,
+		byte(ts >> 7),
+		byte(ts<<1)&0xfe | 0x01,
+	}
+}
+
+// encodePCR 编码 PCR，扩展部分固定为 0。
+func encodePCR(pcr int64) []byte {
+	base := pcr & 0x1ffffffff
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base<<7) | 0x7e,
+		0x00,
+	}
+}
+
+// appendCRC 追加 MPEG-2 CRC32。
+func appendCRC(section []byte) []byte {
+	crc := uint32(0xffffffff)
+	for _, b := range section {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
@@ -6,6 +6,9 @@ import (
 	"net"
 	"net/url"
 	"strings"
+
+	"PinkTide/internal/playlist"
+	"PinkTide/internal/urlsign"
 )
 
 // Rewriter 将原始 M3U8 中的切片地址改写为 CDN 可回源地址。
@@ -13,10 +16,11 @@ type Rewriter struct {
 	cdnPublicURL     string
 	cdnPublicURLs    []string
 	cdnPublicHostMap map[string]string
+	signer           *urlsign.Signer
 }
 
-// New 校验并归一化 CDN 域名，支持多域名用于本地与公网切换。
-func New(cdnPublicURL string) (*Rewriter, error) {
+// New 校验并归一化 CDN 域名，支持多域名用于本地与公网切换；signer 非空时为切片地址附加签名。
+func New(cdnPublicURL string, signer *urlsign.Signer) (*Rewriter, error) {
 	cdnPublicURL = strings.TrimSpace(cdnPublicURL)
 	if cdnPublicURL == "" {
 		return nil, fmt.Errorf("cdn public url is empty")
@@ -42,36 +46,111 @@ func New(cdnPublicURL string) (*Rewriter, error) {
 	if len(urls) == 0 {
 		return nil, fmt.Errorf("cdn public url is empty")
 	}
-	return &Rewriter{cdnPublicURL: urls[0], cdnPublicURLs: urls, cdnPublicHostMap: hostMap}, nil
+	return &Rewriter{cdnPublicURL: urls[0], cdnPublicURLs: urls, cdnPublicHostMap: hostMap, signer: signer}, nil
 }
 
-// Rewrite 保留原有换行风格并重写切片 URL，按请求 Host 选择回源地址。
+// Rewrite 保留原有换行风格并重写切片 URL，按请求 Host 选择回源地址；
+// 主播放列表中的变体与备选播放列表改写为 /playlist 地址，由服务端继续代理与重写。
 func (r *Rewriter) Rewrite(content string, originBase string, requestHost string) (string, error) {
 	if originBase == "" {
 		return "", fmt.Errorf("origin base is empty")
 	}
 	publicURL := r.selectPublicURL(requestHost)
-
-	newline := "\n"
-	if strings.Contains(content, "\r\n") {
-		newline = "\r\n"
+	proxy := func(ref, kind string) (string, error) {
+		resolved, err := resolveURL(originBase, ref)
+		if err != nil {
+			return "", err
+		}
+		return r.proxyURL(publicURL, resolved, kind), nil
 	}
 
-	normalized := strings.ReplaceAll(content, "\r\n", "\n")
-	lines := strings.Split(normalized, "\n")
-	for i, line := range lines {
-		if line == "" || strings.HasPrefix(line, "#") {
+	pl := playlist.Parse(content)
+	for _, tag := range pl.Tags() {
+		if !strings.HasPrefix(tag.Name, "#EXT") || tag.Name == "#EXTINF" || !strings.Contains(tag.Value, "URI=") {
 			continue
 		}
-		resolved, err := resolveURL(originBase, line)
+		for _, attr := range tag.Attrs() {
+			if attr.Name != "URI" || !attr.Quoted || attr.Value == "" {
+				continue
+			}
+			rewritten, err := proxy(attr.Value, tagKind(tag.Name))
+			if err != nil {
+				return "", err
+			}
+			tag.SetAttr("URI", rewritten, true)
+			break
+		}
+	}
+	for _, seg := range pl.Segments {
+		rewritten, err := proxy(seg.URI, "")
+		if err != nil {
+			return "", err
+		}
+		seg.URI = rewritten
+	}
+	for _, v := range pl.Variants {
+		rewritten, err := proxy(v.URI, KindPlaylist)
 		if err != nil {
 			return "", err
 		}
-		payload := base64.URLEncoding.EncodeToString([]byte(resolved))
-		lines[i] = publicURL + "/seg?payload=" + payload
+		v.URI = rewritten
+	}
+	return pl.String(), nil
+}
+
+const (
+	// KindInit 标记 EXT-X-MAP 指向的初始化段，/seg 据此选择类型与缓存时长。
+	KindInit = "init"
+	// KindPart 标记 LL-HLS 分片与预加载提示，/seg 据此使用短缓存。
+	KindPart = "part"
+	// KindPlaylist 标记变体或备选播放列表，改写为 /playlist 地址。
+	KindPlaylist = "playlist"
+)
+
+// tagKind 根据标签名返回 URI 类型，普通媒体切片返回空。
+func tagKind(tag string) string {
+	switch tag {
+	case "#EXT-X-MAP":
+		return KindInit
+	case "#EXT-X-PART", "#EXT-X-PRELOAD-HINT":
+		return KindPart
+	case "#EXT-X-MEDIA", "#EXT-X-I-FRAME-STREAM-INF", "#EXT-X-RENDITION-REPORT":
+		return KindPlaylist
+	}
+	return ""
+}
+
+// proxyURL 将回源地址编码为 /seg 或 /playlist 地址，切片类型非空时附加 kind，配置签名器时附加签名与过期时间；
+// 播放器整个会话都从主播放列表给出的地址重复加载变体，/playlist 地址签名不过期。
+func (r *Rewriter) proxyURL(publicURL, target, kind string) string {
+	payload := base64.URLEncoding.EncodeToString([]byte(target))
+	var link, param string
+	switch kind {
+	case KindPlaylist:
+		link = publicURL + "/playlist?payload=" + payload
+		if r.signer != nil {
+			return link + "&" + r.signer.SignPersistent(SignedPayload(payload, KindPlaylist)).Encode()
+		}
+		return link
+	case "":
+		link = publicURL + "/seg?payload=" + payload
+	default:
+		link = publicURL + "/seg?payload=" + payload + "&kind=" + kind
+		param = kind
 	}
+	if r.signer != nil {
+		link += "&" + r.signer.Sign(SignedPayload(payload, param)).Encode()
+	}
+	return link
+}
 
-	return strings.Join(lines, newline), nil
+// SignedPayload 返回签名覆盖的内容：payload 与切片类型（/seg 的 kind 参数，/playlist 地址为 KindPlaylist），
+// 使决定缓存时长的切片类型无法被篡改，不过期的 /playlist 签名也不能用于 /seg；kind 为空时即 payload 本身。
+func SignedPayload(payload, kind string) string {
+	if kind == "" {
+		return payload
+	}
+	return payload + "\n" + kind
 }
 
 // normalizePublicURL 统一补全协议并提取主机，用于多域名匹配。
@@ -2,11 +2,17 @@ package rewriter
 
 import (
 	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"PinkTide/internal/urlsign"
 )
 
 func TestRewrite(t *testing.T) {
-	r, err := New("https://cdn.example.com")
+	r, err := New("https://cdn.example.com", nil)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -34,6 +40,51 @@ func TestRewrite(t *testing.T) {
 			host:   "cdn.example.com",
 			output: "#EXTM3U\n#EXT-X-VERSION:3\nhttps://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/seg.ts") + "\n",
 		},
+		{
+			name: "tag uri attributes",
+			base: "https://origin.example.com/live/playlist.m3u8",
+			input: "#EXTM3U\n" +
+				"#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"720@0\"\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"key?a=1,b=2\",IV=0x0102\n" +
+				"#EXT-X-KEY:METHOD=NONE\n" +
+				"#EXT-X-PART:DURATION=0.5,URI=\"part-1.m4s\",INDEPENDENT=YES\n" +
+				"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part-2.m4s\"\n" +
+				"#EXTINF:1.000,title,with=comma\n" +
+				"seg.m4s\n",
+			host: "cdn.example.com",
+			output: "#EXTM3U\n" +
+				"#EXT-X-MAP:URI=\"https://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/init.mp4") + "&kind=init\",BYTERANGE=\"720@0\"\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"https://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/key?a=1,b=2") + "\",IV=0x0102\n" +
+				"#EXT-X-KEY:METHOD=NONE\n" +
+				"#EXT-X-PART:DURATION=0.5,URI=\"https://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/part-1.m4s") + "&kind=part\",INDEPENDENT=YES\n" +
+				"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"https://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/part-2.m4s") + "&kind=part\"\n" +
+				"#EXTINF:1.000,title,with=comma\n" +
+				"https://cdn.example.com/seg?payload=" + encode("https://origin.example.com/live/seg.m4s") + "\n",
+		},
+		{
+			name: "media and iframe playlists",
+			base: "https://origin.example.com/live/master.m3u8",
+			input: "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"Main\",URI=\"audio/index.m3u8\"\n" +
+				"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1000,CODECS=\"avc1.640028\",URI=\"iframe.m3u8\"",
+			host: "cdn.example.com",
+			output: "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"Main\",URI=\"https://cdn.example.com/playlist?payload=" + encode("https://origin.example.com/live/audio/index.m3u8") + "\"\n" +
+				"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1000,CODECS=\"avc1.640028\",URI=\"https://cdn.example.com/playlist?payload=" + encode("https://origin.example.com/live/iframe.m3u8") + "\"",
+		},
+		{
+			name: "master playlist variants",
+			base: "https://origin.example.com/live/master.m3u8",
+			input: "#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1920x1080\n" +
+				"1080/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000\n" +
+				"https://other.example.com/720/index.m3u8?token=1\n",
+			host: "cdn.example.com",
+			output: "#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1920x1080\n" +
+				"https://cdn.example.com/playlist?payload=" + encode("https://origin.example.com/live/1080/index.m3u8") + "\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000\n" +
+				"https://cdn.example.com/playlist?payload=" + encode("https://other.example.com/720/index.m3u8?token=1") + "\n",
+		},
 	}
 
 	for _, tc := range cases {
@@ -90,7 +141,7 @@ func TestNew(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			r, err := New(tc.input)
+			r, err := New(tc.input, nil)
 			if tc.wantError {
 				if err == nil {
 					t.Fatalf("expected error")
@@ -108,7 +159,7 @@ func TestNew(t *testing.T) {
 }
 
 func TestRewriteWithHost(t *testing.T) {
-	r, err := New("pinktide.waveyo.cn,localhost:2333")
+	r, err := New("pinktide.waveyo.cn,localhost:2333", nil)
 	if err != nil {
 		t.Fatalf("init failed: %v", err)
 	}
@@ -126,8 +177,63 @@ func TestRewriteWithHost(t *testing.T) {
 	}
 }
 
+func TestRewriteSigned(t *testing.T) {
+	signer, err := urlsign.New([]urlsign.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("init signer failed: %v", err)
+	}
+	r, err := New("https://cdn.example.com", signer)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+
+	got, err := r.Rewrite("#EXTM3U\nseg.ts\n", "https://origin.example.com/live/playlist.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	line := strings.Split(got, "\n")[1]
+	prefix := "https://cdn.example.com/seg?payload="
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	link, err := url.Parse(line)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	query := link.Query()
+	payload := query.Get("payload")
+	if payload != encode("https://origin.example.com/live/seg.ts") {
+		t.Fatalf("unexpected payload: %q", payload)
+	}
+	if err := signer.Verify(payload, query); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	// 初始化段的 kind 参与签名，改写为其他类型后校验失败。
+	got, err = r.Rewrite("#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n", "https://origin.example.com/live/playlist.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	_, rest, _ := strings.Cut(got, `URI="`)
+	raw, _, _ := strings.Cut(rest, `"`)
+	link, err = url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	query = link.Query()
+	payload = query.Get("payload")
+	if err := signer.Verify(SignedPayload(payload, query.Get("kind")), query); err != nil || query.Get("kind") != KindInit {
+		t.Fatalf("verify init failed: %v (kind %q)", err, query.Get("kind"))
+	}
+	for _, kind := range []string{"", KindPart} {
+		if err := signer.Verify(SignedPayload(payload, kind), query); !errors.Is(err, urlsign.ErrBadSignature) {
+			t.Fatalf("expected kind %q to be rejected, got %v", kind, err)
+		}
+	}
+}
+
 func BenchmarkRewrite(b *testing.B) {
-	r, err := New("https://cdn.example.com")
+	r, err := New("https://cdn.example.com", nil)
 	if err != nil {
 		b.Fatalf("init failed: %v", err)
 	}
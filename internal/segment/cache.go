@@ -0,0 +1,118 @@
+package segment
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache 按字节预算缓存切片内容，超出预算时按 LRU 淘汰，过期条目在读取时剔除。
+type Cache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	defaultTTL time.Duration
+	size       int64
+	ll         *list.List
+	items      map[string]*list.Element
+	now        func() time.Time
+}
+
+// cacheEntry 记录单个切片及其过期时间。
+type cacheEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewCache 创建切片缓存，maxBytes 不大于 0 时返回 nil 表示禁用缓存。
+func NewCache(maxBytes int64, defaultTTL time.Duration) *Cache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &Cache{
+		maxBytes:   maxBytes,
+		defaultTTL: defaultTTL,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get 读取未过期的切片并刷新其 LRU 位置。
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.data, true
+}
+
+// Set 写入切片，ttl 不大于 0 时使用默认 TTL，单条超过预算时直接忽略。
+func (c *Cache) Set(key string, data []byte, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.size += size - int64(len(entry.data))
+		entry.data = data
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(elem)
+	} else {
+		elem := c.ll.PushFront(&cacheEntry{key: key, data: data, expiresAt: expiresAt})
+		c.items[key] = elem
+		c.size += size
+	}
+	for c.size > c.maxBytes {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.removeElement(oldest)
+	}
+}
+
+// Stats 返回当前条目数与占用字节数。
+func (c *Cache) Stats() (int, int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.size
+}
+
+// removeElement 删除条目并回收预算，调用方需持有锁。
+func (c *Cache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
@@ -0,0 +1,73 @@
+package segment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(10, time.Minute)
+	c.Set("a", []byte("aaaa"), 0)
+	c.Set("b", []byte("bbbb"), 0)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	c.Set("c", []byte("cccc"), 0)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected b to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Fatalf("expected c to be cached")
+	}
+	if n, size := c.Stats(); n != 2 || size != 8 {
+		t.Fatalf("unexpected stats: %d entries, %d bytes", n, size)
+	}
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewCache(100, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("default", []byte("x"), 0)
+	c.Set("short", []byte("y"), time.Second)
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Fatalf("expected short entry to expire")
+	}
+	if _, ok := c.Get("default"); !ok {
+		t.Fatalf("expected default entry to be cached")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("default"); ok {
+		t.Fatalf("expected default entry to expire")
+	}
+	if n, size := c.Stats(); n != 0 || size != 0 {
+		t.Fatalf("unexpected stats: %d entries, %d bytes", n, size)
+	}
+}
+
+func TestCacheRejectsOversizedEntry(t *testing.T) {
+	c := NewCache(4, time.Minute)
+	c.Set("big", []byte("12345"), 0)
+	if _, ok := c.Get("big"); ok {
+		t.Fatalf("expected oversized entry to be skipped")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := NewCache(0, time.Minute)
+	if c != nil {
+		t.Fatalf("expected nil cache")
+	}
+	c.Set("a", []byte("a"), 0)
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("expected disabled cache to miss")
+	}
+}
@@ -3,43 +3,336 @@ package segment
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 
+	"PinkTide/internal/metrics"
 	"PinkTide/internal/origin"
-
-	"golang.org/x/sync/singleflight"
 )
 
-// Fetcher 使用 SingleFlight 合并相同切片请求，降低源站并发。
+// readChunkSize 控制回源读取粒度，兼顾首字节延迟与系统调用次数。
+const readChunkSize = 32 << 10
+
+// Fetcher 合并相同切片的回源请求并边下载边分发，同时通过字节预算缓存降低源站压力。
 type Fetcher struct {
 	originClient *origin.Client
-	group        singleflight.Group
+	cache        *Cache
+	mu           sync.Mutex
+	flights      map[string]*flight
 }
 
-// NewFetcher 复用回源客户端以统一超时与请求头。
-func NewFetcher(originClient *origin.Client) *Fetcher {
-	return &Fetcher{originClient: originClient}
+// NewFetcher 复用回源客户端以统一超时与请求头，cache 为 nil 时不缓存。
+func NewFetcher(originClient *origin.Client, cache *Cache) *Fetcher {
+	return &Fetcher{
+		originClient: originClient,
+		cache:        cache,
+		flights:      make(map[string]*flight),
+	}
 }
 
-// Fetch 拉取切片内容并返回字节数据，回源失败返回错误。
-func (f *Fetcher) Fetch(ctx context.Context, target string) ([]byte, error) {
-	value, err, _ := f.group.Do(target, func() (interface{}, error) {
-		data, status, err := f.originClient.Get(ctx, target)
-		if err != nil {
-			return nil, err
+// Fetch 拉取完整切片内容并返回字节数据与是否命中缓存，回源失败返回错误；ttl ≤ 0 时使用缓存默认有效期。
+func (f *Fetcher) Fetch(ctx context.Context, target string, ttl time.Duration) ([]byte, bool, error) {
+	body, err := f.Open(ctx, target, ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	defer body.Close()
+	if body.Cached {
+		return body.data, true, nil
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+// Open 返回可流式读取的切片响应体：命中缓存时直接读内存，
+// 否则加入同一目标的进行中回源，首个请求者读取到的数据会同步分发给所有等待者。
+// ttl 为回源完成后写入缓存的有效期，≤ 0 时使用缓存默认值。
+func (f *Fetcher) Open(ctx context.Context, target string, ttl time.Duration) (*Body, error) {
+	if data, ok := f.cache.Get(target); ok {
+		return &Body{Size: int64(len(data)), Cached: true, ctx: ctx, data: data}, nil
+	}
+	return f.join(ctx, target, ttl, nil)
+}
+
+// join 加入 target 的进行中回源，没有时发起新的回源；resp 非空时为已取得的完整响应，
+// 新回源直接分发它而不再请求源站，加入已有回源时关闭它。
+func (f *Fetcher) join(ctx context.Context, target string, ttl time.Duration, resp *http.Response) (*Body, error) {
+	f.mu.Lock()
+	fl, shared := f.flights[target]
+	if !shared {
+		if data, ok := f.cache.Get(target); ok {
+			f.mu.Unlock()
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return &Body{Size: int64(len(data)), Cached: true, ctx: ctx, data: data}, nil
 		}
-		if status != http.StatusOK {
-			return nil, fmt.Errorf("origin status %d", status)
+		fl = newFlight()
+		f.flights[target] = fl
+		// 回源不跟随首个请求者的取消，避免其断开影响其余等待者。
+		go f.run(context.WithoutCancel(ctx), target, ttl, fl, resp)
+	}
+	f.mu.Unlock()
+	if shared && resp != nil {
+		_ = resp.Body.Close()
+	}
+	metrics.Flights.Inc("segment", metrics.FlightResult(shared))
+
+	select {
+	case <-fl.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if fl.status != http.StatusOK {
+		return nil, fl.failure()
+	}
+	return &Body{Size: fl.size, ContentType: fl.contentType, Shared: shared, ctx: ctx, flight: fl}, nil
+}
+
+// Cached 返回缓存中的完整切片，未命中时返回 false。
+func (f *Fetcher) Cached(target string) ([]byte, bool) {
+	return f.cache.Get(target)
+}
+
+// Partial 为源站透传的 206 区间响应，调用方负责关闭 Body。
+type Partial struct {
+	Body         io.ReadCloser
+	ContentRange string
+	ContentType  string
+	Size         int64
+}
+
+// OpenRange 向源站透传 Range 请求：源站返回 206 时得到 Partial；
+// 源站忽略 Range 返回 200 时该响应作为完整回源分发给并发请求者并写入缓存，返回完整数据由调用方在本地切分；
+// 同一切片已有进行中的完整回源或源站返回其他状态时，改为合并后的完整回源。
+func (f *Fetcher) OpenRange(ctx context.Context, target, rangeHeader string, ttl time.Duration) (*Partial, []byte, error) {
+	f.mu.Lock()
+	_, inflight := f.flights[target]
+	f.mu.Unlock()
+	if inflight {
+		data, _, err := f.Fetch(ctx, target, ttl)
+		if err != nil {
+			return nil, nil, err
 		}
-		return data, nil
-	})
+		return nil, data, nil
+	}
+
+	header := http.Header{}
+	header.Set("Range", rangeHeader)
+	// 完整响应可能交给合并回源继续读取，请求不跟随调用方取消；206 响应体由调用方关闭。
+	resp, err := f.originClient.Open(context.WithoutCancel(ctx), target, header)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return &Partial{
+			Body:         resp.Body,
+			ContentRange: resp.Header.Get("Content-Range"),
+			ContentType:  resp.Header.Get("Content-Type"),
+			Size:         resp.ContentLength,
+		}, nil, nil
+	case http.StatusOK:
+		body, err := f.join(ctx, target, ttl, resp)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer body.Close()
+		if body.Cached {
+			return nil, body.data, nil
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, data, nil
+	default:
+		_ = resp.Body.Close()
+		data, _, err := f.Fetch(ctx, target, ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, data, nil
 	}
+}
 
-	result, ok := value.([]byte)
-	if !ok {
-		return nil, fmt.Errorf("invalid response type")
+// run 执行一次回源并把响应体写入 flight，完整成功时写入缓存；resp 非空时直接读取该响应。
+func (f *Fetcher) run(ctx context.Context, target string, ttl time.Duration, fl *flight, resp *http.Response) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.flights, target)
+		f.mu.Unlock()
+	}()
+
+	if resp == nil {
+		var err error
+		resp, err = f.originClient.Open(ctx, target, nil)
+		if err != nil {
+			fl.start(0, -1, "", err)
+			fl.finish(err)
+			return
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("origin status %d", resp.StatusCode)
+		fl.start(resp.StatusCode, -1, "", err)
+		fl.finish(err)
+		return
+	}
+	fl.start(resp.StatusCode, resp.ContentLength, resp.Header.Get("Content-Type"), nil)
+
+	chunk := make([]byte, readChunkSize)
+	for {
+		n, readErr := resp.Body.Read(chunk)
+		if n > 0 {
+			fl.append(chunk[:n])
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			fl.finish(fmt.Errorf("read response failed: %w", readErr))
+			return
+		}
+	}
+	if fl.size >= 0 && int64(len(fl.bytes())) != fl.size {
+		fl.finish(fmt.Errorf("short response: %w", io.ErrUnexpectedEOF))
+		return
+	}
+	f.cache.Set(target, fl.bytes(), ttl)
+	fl.finish(nil)
+}
+
+// flight 表示一次进行中的回源，数据追加后通知所有读取方。
+type flight struct {
+	mu     sync.Mutex
+	ready  chan struct{}
+	notify chan struct{}
+	buf    []byte
+	done   bool
+	err    error
+	status int
+	size   int64
+
+	contentType string
+}
+
+func newFlight() *flight {
+	return &flight{
+		ready:  make(chan struct{}),
+		notify: make(chan struct{}),
+		size:   -1,
 	}
-	return result, nil
+}
+
+// start 记录响应头信息并唤醒等待响应头的请求者。
+func (fl *flight) start(status int, size int64, contentType string, err error) {
+	fl.mu.Lock()
+	fl.status = status
+	fl.size = size
+	fl.contentType = contentType
+	fl.err = err
+	fl.mu.Unlock()
+	close(fl.ready)
+}
+
+// append 追加新到达的数据并广播。
+func (fl *flight) append(p []byte) {
+	fl.mu.Lock()
+	fl.buf = append(fl.buf, p...)
+	close(fl.notify)
+	fl.notify = make(chan struct{})
+	fl.mu.Unlock()
+}
+
+// finish 标记回源结束，err 非空时读取方将收到该错误。
+func (fl *flight) finish(err error) {
+	fl.mu.Lock()
+	fl.done = true
+	fl.err = err
+	close(fl.notify)
+	fl.notify = make(chan struct{})
+	fl.mu.Unlock()
+}
+
+// bytes 返回当前已接收的数据。
+func (fl *flight) bytes() []byte {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.buf
+}
+
+// failure 返回响应头阶段的失败原因。
+func (fl *flight) failure() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.err != nil {
+		return fl.err
+	}
+	return fmt.Errorf("origin status %d", fl.status)
+}
+
+// Body 为切片响应体，Size 为 -1 时表示源站未给出长度，ContentType 为源站声明的类型（命中缓存时为空）。
+type Body struct {
+	Size        int64
+	ContentType string
+	Cached      bool
+	Shared      bool
+	ctx         context.Context
+	data        []byte
+	flight      *flight
+	off         int
+}
+
+// Read 读取已到达的数据，尚未到达时阻塞等待，请求取消时返回 ctx 错误。
+func (b *Body) Read(p []byte) (int, error) {
+	if b.flight == nil {
+		if b.off >= len(b.data) {
+			return 0, io.EOF
+		}
+		n := copy(p, b.data[b.off:])
+		b.off += n
+		return n, nil
+	}
+
+	fl := b.flight
+	for {
+		fl.mu.Lock()
+		if b.off < len(fl.buf) {
+			n := copy(p, fl.buf[b.off:])
+			b.off += n
+			fl.mu.Unlock()
+			return n, nil
+		}
+		if fl.done {
+			err := fl.err
+			fl.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		notify := fl.notify
+		fl.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-b.ctx.Done():
+			return 0, b.ctx.Err()
+		}
+	}
+}
+
+// Close 释放读取方引用，回源本身会继续完成以写入缓存。
+func (b *Body) Close() error {
+	b.flight = nil
+	b.data = nil
+	return nil
 }
@@ -0,0 +1,144 @@
+package segment
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PinkTide/internal/origin"
+)
+
+func TestFetcherStreamsToSharedWaiters(t *testing.T) {
+	release := make(chan struct{})
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("first-"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("second"))
+	}))
+	defer srv.Close()
+
+	fetcher := NewFetcher(origin.NewClient(5*time.Second, nil), NewCache(1<<20, time.Minute))
+	ctx := context.Background()
+	target := srv.URL + "/seg.ts"
+
+	first, err := fetcher.Open(ctx, target, 0)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(first, buf); err != nil {
+		t.Fatalf("read first chunk failed: %v", err)
+	}
+	if string(buf) != "first-" {
+		t.Fatalf("unexpected first chunk: %q", buf)
+	}
+
+	second, err := fetcher.Open(ctx, target, 0)
+	if err != nil {
+		t.Fatalf("open shared failed: %v", err)
+	}
+	if !second.Shared {
+		t.Fatalf("expected second reader to share the flight")
+	}
+	close(release)
+
+	rest, err := io.ReadAll(first)
+	if err != nil || string(rest) != "second" {
+		t.Fatalf("unexpected rest: %q, %v", rest, err)
+	}
+	all, err := io.ReadAll(second)
+	if err != nil || string(all) != "first-second" {
+		t.Fatalf("unexpected shared body: %q, %v", all, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, cached, err := fetcher.Fetch(ctx, target, 0)
+		if err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+		if string(data) != "first-second" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+		if cached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cached result")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("unexpected origin hits: %d", n)
+	}
+}
+
+func TestFetcherOriginStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	fetcher := NewFetcher(origin.NewClient(5*time.Second, nil), nil)
+	if _, _, err := fetcher.Fetch(context.Background(), srv.URL+"/missing.ts", 0); err == nil {
+		t.Fatalf("expected error for non-200 origin")
+	}
+}
+
+func TestFetcherOpenRangeSharesFullResponse(t *testing.T) {
+	release := make(chan struct{})
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 源站忽略 Range，返回完整切片。
+		hits.Add(1)
+		_, _ = w.Write([]byte("first-"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("second"))
+	}))
+	defer srv.Close()
+
+	fetcher := NewFetcher(origin.NewClient(5*time.Second, nil), NewCache(1<<20, time.Minute))
+	target := srv.URL + "/seg.ts"
+	results := make(chan string, 2)
+	openRange := func() {
+		partial, data, err := fetcher.OpenRange(context.Background(), target, "bytes=0-3", 0)
+		if err != nil || partial != nil {
+			t.Errorf("unexpected range result: %v, %v", partial, err)
+		}
+		results <- string(data)
+	}
+
+	go openRange()
+	deadline := time.Now().Add(time.Second)
+	for {
+		fetcher.mu.Lock()
+		_, inflight := fetcher.flights[target]
+		fetcher.mu.Unlock()
+		if inflight {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected full response to become a shared flight")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	go openRange()
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if got := <-results; got != "first-second" {
+			t.Fatalf("unexpected data: %q", got)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("unexpected origin hits: %d", n)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"PinkTide/internal/clip"
+	"PinkTide/internal/dvr"
+)
+
+// clipTimeLayout 为剪辑响应中时间的输出格式。
+const clipTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// clipRange 为剪辑范围不可用时的响应体。
+type clipRange struct {
+	Error         string `json:"error"`
+	AvailableFrom string `json:"available_from,omitempty"`
+	AvailableTo   string `json:"available_to,omitempty"`
+}
+
+// handleClip 将时移窗口中覆盖指定墙上时间范围的 TS 切片拼接为单个文件下载。
+func (s *Server) handleClip(w http.ResponseWriter, r *http.Request) {
+	s.setCors(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "method", r.Method},
+				requestFields(r)...,
+			)
+			s.logger.Warn("method not allowed", fields...)
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dvr == nil {
+		http.Error(w, "dvr disabled", http.StatusNotFound)
+		return
+	}
+
+	roomID, ok := s.resolveRoomID(r)
+	if !ok {
+		http.Error(w, "missing room_id", http.StatusBadRequest)
+		return
+	}
+	opts, err := s.resolvePlayOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseClipTime(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := parseClipTime(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	// 下播后时移窗口仍可剪辑，只借用房间状态把短号归一为长号。
+	state, _ := s.inspectRoomState(r.Context(), roomID)
+	roomID = state.RoomID
+
+	result, err := s.dvr.Clip(pollerKey(roomID, opts), from, to)
+	if err != nil {
+		body := clipRange{Error: err.Error()}
+		if errors.Is(err, dvr.ErrRangeUnavailable) {
+			body.AvailableFrom = result.Start.UTC().Format(clipTimeLayout)
+			body.AvailableTo = result.End.UTC().Format(clipTimeLayout)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	// 长度取采集时记录的字节数；发出响应头前只检查文件仍存在，不占用文件描述符，逐个切片读取后立即关闭。
+	var size int64
+	for _, seg := range result.Segments {
+		if seg.Init || filepath.Ext(seg.Path) != ".ts" {
+			http.Error(w, "clip requires mpeg-ts segments", http.StatusNotAcceptable)
+			return
+		}
+		if _, err := os.Stat(seg.Path); err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "range no longer available", http.StatusGone)
+				return
+			}
+			if s.logger != nil {
+				fields := append([]any{"room_id", roomID, "path", r.URL.Path, "error", err}, requestFields(r)...)
+				s.logger.Error("clip segment unavailable", fields...)
+			}
+			http.Error(w, "clip segment unavailable", http.StatusInternalServerError)
+			return
+		}
+		size += seg.Size
+	}
+
+	name := fmt.Sprintf("%s-%d-%d.ts", roomID, from.Unix(), to.Unix())
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Clip-Start", result.Start.UTC().Format(clipTimeLayout))
+	w.Header().Set("X-Clip-End", result.End.UTC().Format(clipTimeLayout))
+	w.Header().Set("X-Clip-Complete", strconv.FormatBool(result.Complete))
+	// 剪辑可能较大，不受全局写超时限制。
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	joiner := clip.NewJoiner()
+	var written int64
+	for _, seg := range result.Segments {
+		data, err := os.ReadFile(seg.Path)
+		if err == nil {
+			var n int
+			n, err = joiner.Append(w, data, seg.Duration, seg.Discontinuity)
+			written += int64(n)
+		}
+		if err != nil {
+			// 响应头已发出，切片在读取前滑出窗口时只能截断，客户端可据 Content-Length 发现。
+			if s.logger != nil {
+				fields := append(
+					[]any{"room_id", roomID, "path", r.URL.Path, "bytes", written, "error", err},
+					requestFields(r)...,
+				)
+				s.logger.Warn("clip interrupted", fields...)
+			}
+			return
+		}
+	}
+	if s.logger != nil {
+		fields := append(
+			[]any{"room_id", roomID, "path", r.URL.Path, "segments", len(result.Segments), "bytes", written, "complete", result.Complete},
+			requestFields(r)...,
+		)
+		s.logger.Info("clip served", fields...)
+	}
+}
+
+// parseClipTime 解析 RFC 3339 时间或 Unix 秒（可带小数）。
+func parseClipTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, fmt.Errorf("missing time")
+	}
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		if seconds <= 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			return time.Time{}, fmt.Errorf("invalid time")
+		}
+		sec, frac := math.Modf(seconds)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
@@ -4,23 +4,49 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"PinkTide/internal/bili"
+	"PinkTide/internal/dvr"
+	"PinkTide/internal/metrics"
+	"PinkTide/internal/origin"
+	"PinkTide/internal/remux"
+	"PinkTide/internal/rewriter"
+	"PinkTide/internal/segment"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/trace"
+	"PinkTide/internal/urlsign"
 )
 
-// registerRoutes 统一注册对外路由，便于后续扩展。
+// registerRoutes 统一注册对外路由，便于后续扩展；每个路由按注册路径统计请求指标。
 func (s *Server) registerRoutes() {
-	s.serveMux.HandleFunc("/api", s.handleRoot)
-	s.serveMux.HandleFunc("/api/", s.handleRoot)
-	s.serveMux.HandleFunc("/api/status", s.handleRoomStatus)
-	s.serveMux.HandleFunc("/api/watch", s.handleRoomWatch)
-	s.serveMux.HandleFunc("/ui", s.handleUI)
-	s.serveMux.HandleFunc("/ui/", s.handleUI)
-	s.serveMux.HandleFunc("/live.m3u8", s.handleM3U8)
-	s.serveMux.HandleFunc("/seg", s.handleSegment)
-	s.serveMux.Handle("/", http.FileServer(http.Dir("ui")))
+	s.handle("/api", http.HandlerFunc(s.handleRoot))
+	s.handle("/api/", http.HandlerFunc(s.handleRoot))
+	s.handle("/api/status", http.HandlerFunc(s.handleRoomStatus))
+	s.handle("/api/watch", http.HandlerFunc(s.handleRoomWatch))
+	s.handle("/api/clip", http.HandlerFunc(s.handleClip))
+	s.handle("/ui", http.HandlerFunc(s.handleUI))
+	s.handle("/ui/", http.HandlerFunc(s.handleUI))
+	s.handle("/live.m3u8", http.HandlerFunc(s.handleM3U8))
+	s.handle("/live.flv", http.HandlerFunc(s.handleFLV))
+	s.handle("/playlist", http.HandlerFunc(s.handlePlaylist))
+	s.handle("/seg", http.HandlerFunc(s.handleSegment))
+	if s.cfg.MetricsEnabled {
+		s.serveMux.Handle("/metrics", metrics.Default.Handler())
+	}
+	s.handle("/", http.FileServer(http.Dir("ui")))
+}
+
+// handle 注册路由并包装追踪 span 与请求计数、耗时统计。
+func (s *Server) handle(pattern string, handler http.Handler) {
+	s.serveMux.Handle(pattern, trace.Middleware(pattern, metrics.Instrument(pattern, handler)))
 }
 
 func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
@@ -90,8 +116,13 @@ func (s *Server) handleRoomStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing room_id", http.StatusBadRequest)
 		return
 	}
+	opts, err := s.resolvePlayOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	state, code := s.inspectStreamState(r.Context(), roomID)
+	state, code := s.inspectStreamState(r.Context(), roomID, opts)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	_ = json.NewEncoder(w).Encode(state)
@@ -120,6 +151,11 @@ func (s *Server) handleRoomWatch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing room_id", http.StatusBadRequest)
 		return
 	}
+	opts, err := s.resolvePlayOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -127,15 +163,19 @@ func (s *Server) handleRoomWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 事件流为长连接，不受服务端写超时限制。
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	metrics.SSEWatchers.Inc()
+	defer metrics.SSEWatchers.Dec()
 
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
 	for {
-		state, code := s.inspectStreamState(r.Context(), roomID)
+		state, code := s.inspectStreamState(r.Context(), roomID, opts)
 		payload, _ := json.Marshal(state)
 		_, _ = fmt.Fprintf(w, "event: status\ndata: %s\n\n", payload)
 		flusher.Flush()
@@ -188,106 +228,290 @@ func (s *Server) handleM3U8(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts, err := s.resolvePlayOptions(r)
+	if err != nil {
+		if s.logger != nil {
+			fields := append([]any{"path", r.URL.Path, "error", err}, requestFields(r)...)
+			s.logger.Warn("invalid play options", fields...)
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msn, part, blocking, err := parseBlockingReload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeShift, err := parseTimeShift(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeShift && s.dvr == nil {
+		http.Error(w, "dvr disabled", http.StatusNotFound)
+		return
+	}
+
 	state, code := s.inspectRoomState(r.Context(), roomID)
 	if code != http.StatusOK {
 		w.WriteHeader(code)
 		_, _ = w.Write([]byte(state.Message))
 		return
 	}
+	roomID = state.RoomID
 
-	roomIDParam := r.URL.Query().Get("room_id")
-	var originBase string
-	if roomIDParam == "" {
-		if s.resolver == nil {
-			if s.logger != nil {
-				fields := append([]any{"path", r.URL.Path}, requestFields(r)...)
-				s.logger.Warn("missing room id", fields...)
-			}
-			http.Error(w, "missing room_id", http.StatusBadRequest)
-			return
+	// 开启时移后直播访问同时驱动采集，使后加入的观众可以回看。
+	live := func(ctx context.Context) (*stream.Snapshot, error) {
+		return s.playlistSnapshot(ctx, roomID, opts)
+	}
+	var snap *stream.Snapshot
+	if timeShift {
+		snap, err = s.dvr.Snapshot(r.Context(), pollerKey(roomID, opts), live)
+	} else {
+		snap, err = live(r.Context())
+		if err == nil && s.dvr != nil {
+			s.dvr.Track(pollerKey(roomID, opts), live)
 		}
-		originBase = s.resolver.Get()
-		if originBase == "" {
-			w.WriteHeader(http.StatusAccepted)
-			_, _ = w.Write([]byte("等待加载"))
+	}
+	if err != nil {
+		s.writeLiveError(w, r, roomID, opts, err)
+		return
+	}
+
+	cacheControl := "public, max-age=1"
+	if blocking && snap.LowLatency() {
+		// 请求超前最新切片两个以上时按 LL-HLS 约定直接拒绝，避免长时间挂起。
+		if msn > snap.NextMSN()+2 {
+			http.Error(w, "_HLS_msn too far ahead", http.StatusBadRequest)
 			return
 		}
-	} else {
-		var err error
-		originBase, err = s.biliClient.FetchPlayURL(r.Context(), roomID)
+		ctx, cancel := context.WithTimeout(r.Context(), 3*snap.TargetDuration)
+		snap, err = s.pollers.Await(ctx, pollerKey(roomID, opts), s.playURLSource(roomID, opts), msn, part)
+		cancel()
 		if err != nil {
 			if s.logger != nil {
 				fields := append(
-					[]any{"room_id", roomID, "path", r.URL.Path, "error", err},
+					[]any{"room_id", roomID, "msn", msn, "part", part, "path", r.URL.Path, "error", err},
 					requestFields(r)...,
 				)
-				s.logger.Error("fetch play url failed", fields...)
+				s.logger.Warn("blocking reload failed", fields...)
 			}
-			w.WriteHeader(http.StatusAccepted)
-			_, _ = w.Write([]byte("等待加载"))
+			http.Error(w, "blocking reload timeout", http.StatusServiceUnavailable)
 			return
 		}
+		// 阻塞刷新地址携带序号，内容对同一地址稳定，可交给 CDN 缓存更久。
+		cacheControl = fmt.Sprintf("public, max-age=%d", int(6*snap.TargetDuration/time.Second))
 	}
 
-	data, status, err := s.origin.Get(r.Context(), originBase)
+	rewritten, err := snap.Rewritten(r.Host, func(content, originBase string) (string, error) {
+		return s.rewriter.Rewrite(content, originBase, r.Host)
+	})
 	if err != nil {
 		if s.logger != nil {
 			fields := append(
 				[]any{"room_id", roomID, "path", r.URL.Path, "error", err},
 				requestFields(r)...,
 			)
-			s.logger.Error("fetch m3u8 failed", fields...)
+			s.logger.Error("rewrite failed", fields...)
 		}
-		w.WriteHeader(http.StatusAccepted)
-		_, _ = w.Write([]byte("加载中"))
+		http.Error(w, "rewrite error", http.StatusInternalServerError)
 		return
 	}
-	if status != http.StatusOK {
+	if s.logger != nil {
+		fields := append(
+			[]any{"room_id", roomID, "options", opts.String(), "dvr", timeShift, "path", r.URL.Path},
+			requestFields(r)...,
+		)
+		s.logger.Debug("m3u8 served", fields...)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", cacheControl)
+	if health, _ := s.resolvers.Health(roomID, opts); health.Stale(time.Now()) {
+		// 播放地址刷新失败时仍提供旧地址的播放列表，通过响应头标明以便排查。
+		w.Header().Set("X-Play-URL-Stale", "true")
+	}
+	_, _ = w.Write([]byte(rewritten))
+}
+
+// handleFLV 为房间建立共享的上游 FLV 连接并向观众转发标签流。
+func (s *Server) handleFLV(w http.ResponseWriter, r *http.Request) {
+	s.setCors(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
 		if s.logger != nil {
 			fields := append(
-				[]any{"room_id", roomID, "path", r.URL.Path, "status", status},
+				[]any{"path", r.URL.Path, "method", r.Method},
 				requestFields(r)...,
 			)
-			s.logger.Error("fetch m3u8 failed", fields...)
+			s.logger.Warn("method not allowed", fields...)
 		}
-		w.WriteHeader(http.StatusAccepted)
-		_, _ = w.Write([]byte("加载中"))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, ok := s.resolveRoomID(r)
+	if !ok {
+		http.Error(w, "missing room_id", http.StatusBadRequest)
+		return
+	}
+	opts, err := s.resolvePlayOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts = flvOptions(opts)
+
+	state, code := s.inspectRoomState(r.Context(), roomID)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(state.Message))
+		return
+	}
+	roomID = state.RoomID
+
+	info, err := s.resolvers.Get(r.Context(), roomID, opts)
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"room_id", roomID, "path", r.URL.Path, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Error("fetch play url failed", fields...)
+		}
+		if errors.Is(err, stream.ErrTooManyRooms) {
+			http.Error(w, "too many rooms", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "play url unavailable", http.StatusBadGateway)
+		return
+	}
+	if info.Format != bili.FormatFLV {
+		http.Error(w, "flv unavailable", http.StatusNotAcceptable)
 		return
 	}
 
-	rewritten, err := s.rewriter.Rewrite(string(data), originBase, r.Host)
+	viewer, err := s.flvHub.Subscribe(r.Context(), "flv:"+pollerKey(roomID, opts), s.playURLSource(roomID, opts))
 	if err != nil {
 		if s.logger != nil {
 			fields := append(
 				[]any{"room_id", roomID, "path", r.URL.Path, "error", err},
 				requestFields(r)...,
 			)
+			s.logger.Error("open flv relay failed", fields...)
+		}
+		writeFetchError(w, err)
+		return
+	}
+	defer viewer.Close()
+
+	// 长连接不受服务端写超时限制，断开由客户端或上游结束决定。
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	var written int64
+	for {
+		chunk, err := viewer.Next(r.Context())
+		if err != nil {
+			if s.logger != nil {
+				fields := append(
+					[]any{"room_id", roomID, "path", r.URL.Path, "bytes", written, "error", err},
+					requestFields(r)...,
+				)
+				s.logger.Debug("flv viewer left", fields...)
+			}
+			return
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// handlePlaylist 代理主播放列表中的变体与备选播放列表，并按媒体播放列表规则重写。
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	s.setCors(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "method", r.Method},
+				requestFields(r)...,
+			)
+			s.logger.Warn("method not allowed", fields...)
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, ok := s.verifyPayload(w, r, rewriter.KindPlaylist)
+	if !ok {
+		return
+	}
+
+	snap, err := s.pollers.Snapshot(r.Context(), "playlist:"+target, func(context.Context) (string, error) {
+		return target, nil
+	})
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Error("fetch playlist failed", fields...)
+		}
+		writeFetchError(w, err)
+		return
+	}
+
+	rewritten, err := snap.Rewritten(r.Host, func(content, originBase string) (string, error) {
+		return s.rewriter.Rewrite(content, originBase, r.Host)
+	})
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "error", err},
+				requestFields(r)...,
+			)
 			s.logger.Error("rewrite failed", fields...)
 		}
 		http.Error(w, "rewrite error", http.StatusInternalServerError)
 		return
 	}
-	if s.logger != nil {
-		fields := append(
-			[]any{"room_id", roomID, "path", r.URL.Path},
-			requestFields(r)...,
-		)
-		s.logger.Debug("m3u8 served", fields...)
-	}
 
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.Header().Set("Cache-Control", "public, max-age=1")
+	w.Header().Set("Content-Length", strconv.Itoa(len(rewritten)))
+	if r.Method == http.MethodHead {
+		return
+	}
 	_, _ = w.Write([]byte(rewritten))
 }
 
-// handleSegment 拉取切片并返回，便于 CDN 长缓存。
+// handleSegment 拉取切片并返回，便于 CDN 长缓存，支持 HEAD 与单区间 Range。
 func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 	s.setCors(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		if s.logger != nil {
 			fields := append(
 				[]any{"path", r.URL.Path, "method", r.Method},
@@ -299,6 +523,159 @@ func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	kind := r.URL.Query().Get("kind")
+	var ttl time.Duration
+	switch kind {
+	case "":
+	case rewriter.KindInit:
+		ttl = initSegmentTTL
+	case rewriter.KindPart:
+		ttl = partSegmentTTL
+	default:
+		http.Error(w, "invalid kind", http.StatusBadRequest)
+		return
+	}
+
+	target, ok := s.verifyPayload(w, r, kind)
+	if !ok {
+		return
+	}
+
+	etag := segmentETag(target)
+	w.Header().Set("Cache-Control", segmentCacheControl(kind))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" && !ifRangeMatches(r.Header.Get("If-Range"), etag) {
+		rangeHeader = ""
+	}
+	if strings.HasPrefix(target, remux.Scheme+"://") {
+		s.serveLocalSegment(w, r, target, kind, rangeHeader, s.remux.Segment, remux.Scheme)
+		return
+	}
+	if strings.HasPrefix(target, dvr.Scheme+"://") && s.dvr != nil {
+		s.serveLocalSegment(w, r, target, kind, rangeHeader, s.dvr.Segment, dvr.Scheme)
+		return
+	}
+	if rangeHeader != "" {
+		if err := checkRangeHeader(rangeHeader); errors.Is(err, errMultiRange) {
+			if s.logger != nil {
+				fields := append(
+					[]any{"path", r.URL.Path, "range", rangeHeader, "error", err},
+					requestFields(r)...,
+				)
+				s.logger.Warn("segment range rejected", fields...)
+			}
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		s.serveSegmentRange(w, r, target, kind, rangeHeader, ttl)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		data, hit, err := s.segFetcher.Fetch(r.Context(), target, ttl)
+		if err != nil {
+			if s.logger != nil {
+				fields := append(
+					[]any{"path", r.URL.Path, "error", err},
+					requestFields(r)...,
+				)
+				s.logger.Error("fetch segment failed", fields...)
+			}
+			writeFetchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", segmentContentType(target, "", data, kind))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		w.WriteHeader(http.StatusOK)
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "method", r.Method, "bytes", 0, "cache", cacheResult(hit)},
+				requestFields(r)...,
+			)
+			s.logger.Debug("segment served", fields...)
+		}
+		return
+	}
+
+	body, err := s.segFetcher.Open(r.Context(), target, ttl)
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Error("fetch segment failed", fields...)
+		}
+		writeFetchError(w, err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", segmentContentType(target, body.ContentType, nil, kind))
+	if body.Size >= 0 {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", body.Size))
+	}
+	w.WriteHeader(http.StatusOK)
+	written, err := copyFlush(w, body)
+	metrics.SegmentBytes.Add(float64(written))
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "bytes", written, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Warn("segment stream interrupted", fields...)
+		}
+		return
+	}
+	if s.logger != nil {
+		fields := append(
+			[]any{"path", r.URL.Path, "bytes", written, "cache", cacheResult(body.Cached), "shared", body.Shared},
+			requestFields(r)...,
+		)
+		s.logger.Debug("segment served", fields...)
+	}
+}
+
+// serveLocalSegment 返回转封装或时移存储中的本地切片，切片已滑出保留范围时返回 404。
+func (s *Server) serveLocalSegment(w http.ResponseWriter, r *http.Request, target, kind, rangeHeader string, lookup func(string) ([]byte, bool), source string) {
+	data, ok := lookup(target)
+	if !ok {
+		if s.logger != nil {
+			fields := append([]any{"path", r.URL.Path, "source", source}, requestFields(r)...)
+			s.logger.Warn("local segment expired", fields...)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "segment expired", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", segmentContentType(target, "", data, kind))
+	written, err := writeRange(w, r, data, rangeHeader)
+	metrics.SegmentBytes.Add(float64(written))
+	if err != nil {
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "range", rangeHeader, "bytes", written, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Warn("segment range failed", fields...)
+		}
+		return
+	}
+	if s.logger != nil {
+		fields := append(
+			[]any{"path", r.URL.Path, "method", r.Method, "bytes", written, "cache", source},
+			requestFields(r)...,
+		)
+		s.logger.Debug("segment served", fields...)
+	}
+}
+
+// verifyPayload 按切片类型 kind 校验签名并解码 payload 中的回源地址，失败时写入错误响应。
+func (s *Server) verifyPayload(w http.ResponseWriter, r *http.Request, kind string) (string, bool) {
 	payload := r.URL.Query().Get("payload")
 	if payload == "" {
 		if s.logger != nil {
@@ -306,7 +683,20 @@ func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 			s.logger.Warn("missing payload", fields...)
 		}
 		http.Error(w, "missing payload", http.StatusBadRequest)
-		return
+		return "", false
+	}
+
+	if err := s.signer.Verify(rewriter.SignedPayload(payload, kind), r.URL.Query()); err != nil {
+		reason, code := signatureRejection(err)
+		if s.logger != nil {
+			fields := append(
+				[]any{"path", r.URL.Path, "reason", reason, "error", err},
+				requestFields(r)...,
+			)
+			s.logger.Warn("payload rejected", fields...)
+		}
+		http.Error(w, err.Error(), code)
+		return "", false
 	}
 
 	decoded, err := base64.URLEncoding.DecodeString(payload)
@@ -319,7 +709,7 @@ func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 			s.logger.Warn("payload decode failed", fields...)
 		}
 		http.Error(w, "decode error", http.StatusBadRequest)
-		return
+		return "", false
 	}
 	target := string(decoded)
 	if target == "" {
@@ -328,40 +718,146 @@ func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
 			s.logger.Warn("payload empty", fields...)
 		}
 		http.Error(w, "decode error", http.StatusBadRequest)
-		return
+		return "", false
 	}
+	return target, true
+}
 
-	data, err := s.segFetcher.Fetch(r.Context(), target)
+// serveSegmentRange 输出单区间响应：命中缓存时本地切分，否则透传给源站，
+// 源站不支持区间时退化为完整回源后本地切分。
+func (s *Server) serveSegmentRange(w http.ResponseWriter, r *http.Request, target, kind, rangeHeader string, ttl time.Duration) {
+	data, hit := s.segFetcher.Cached(target)
+	var partial *segment.Partial
+	if !hit {
+		var err error
+		if r.Method == http.MethodHead {
+			data, hit, err = s.segFetcher.Fetch(r.Context(), target, ttl)
+		} else {
+			partial, data, err = s.segFetcher.OpenRange(r.Context(), target, rangeHeader, ttl)
+		}
+		if err != nil {
+			if s.logger != nil {
+				fields := append(
+					[]any{"path", r.URL.Path, "range", rangeHeader, "error", err},
+					requestFields(r)...,
+				)
+				s.logger.Error("fetch segment failed", fields...)
+			}
+			writeFetchError(w, err)
+			return
+		}
+	}
+
+	var (
+		written int64
+		err     error
+	)
+	if partial != nil {
+		defer partial.Body.Close()
+		w.Header().Set("Content-Type", segmentContentType(target, partial.ContentType, nil, kind))
+		w.Header().Set("Content-Range", partial.ContentRange)
+		if partial.Size >= 0 {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", partial.Size))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		written, err = copyFlush(w, partial.Body)
+	} else {
+		w.Header().Set("Content-Type", segmentContentType(target, "", data, kind))
+		written, err = writeRange(w, r, data, rangeHeader)
+	}
+	metrics.SegmentBytes.Add(float64(written))
 	if err != nil {
 		if s.logger != nil {
 			fields := append(
-				[]any{"path", r.URL.Path, "error", err},
+				[]any{"path", r.URL.Path, "range", rangeHeader, "bytes", written, "error", err},
 				requestFields(r)...,
 			)
-			s.logger.Error("fetch segment failed", fields...)
+			s.logger.Warn("segment range failed", fields...)
 		}
-		http.Error(w, "fetch error", http.StatusBadGateway)
 		return
 	}
 	if s.logger != nil {
 		fields := append(
-			[]any{"path", r.URL.Path, "bytes", len(data)},
+			[]any{"path", r.URL.Path, "method", r.Method, "range", rangeHeader, "bytes", written, "cache", cacheResult(hit), "passthrough", partial != nil},
 			requestFields(r)...,
 		)
 		s.logger.Debug("segment served", fields...)
 	}
+}
 
-	w.Header().Set("Content-Type", "video/mp2t")
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
-	_, _ = w.Write(data)
+// copyFlush 边读边写并及时 Flush，使客户端尽早收到首字节。
+func copyFlush(w http.ResponseWriter, src io.Reader) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	var written int64
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			m, writeErr := w.Write(buf[:n])
+			written += int64(m)
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
+
+// writeFetchError 输出切片回源失败响应，策略拒绝时返回 403 以区分源站故障。
+func writeFetchError(w http.ResponseWriter, err error) {
+	// 回源失败不可被 CDN 长期缓存，尤其是尚未生成的预加载分片。
+	w.Header().Set("Cache-Control", "no-store")
+	if errors.Is(err, origin.ErrDenied) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "fetch error", http.StatusBadGateway)
+}
+
+// signatureRejection 将签名校验错误映射为日志原因与响应状态码。
+func signatureRejection(err error) (string, int) {
+	switch {
+	case errors.Is(err, urlsign.ErrUnsigned):
+		return "unsigned", http.StatusForbidden
+	case errors.Is(err, urlsign.ErrUnknownKey):
+		return "unknown_key", http.StatusForbidden
+	case errors.Is(err, urlsign.ErrExpired):
+		return "expired", http.StatusGone
+	default:
+		return "bad_signature", http.StatusForbidden
+	}
+}
+
+// cacheResult 将缓存命中状态转换为日志字段值。
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
 }
 
 type streamState struct {
-	RoomID     string `json:"room_id"`
-	LiveStatus int    `json:"live_status"`
-	State      string `json:"state"`
-	Message    string `json:"message"`
+	RoomID      string         `json:"room_id"`
+	ShortID     int            `json:"short_id"`
+	RequestedID string         `json:"requested_id"`
+	LiveStatus  int            `json:"live_status"`
+	Qn          int            `json:"qn,omitempty"`
+	Protocol    string         `json:"protocol,omitempty"`
+	Format      string         `json:"format,omitempty"`
+	Codec       string         `json:"codec,omitempty"`
+	State       string         `json:"state"`
+	Message     string         `json:"message"`
+	Qualities   []bili.Quality `json:"qualities,omitempty"`
+	Stale       bool           `json:"stale,omitempty"`
+	Resolver    *resolverState `json:"resolver,omitempty"`
 }
 
 func (s *Server) resolveRoomID(r *http.Request) (string, bool) {
@@ -375,13 +871,83 @@ func (s *Server) resolveRoomID(r *http.Request) (string, bool) {
 	return s.cfg.BiliRoomID, true
 }
 
+// parseBlockingReload 读取 LL-HLS 阻塞刷新参数 _HLS_msn 与 _HLS_part，未指定分片时 part 为 -1。
+func parseBlockingReload(r *http.Request) (int64, int, bool, error) {
+	query := r.URL.Query()
+	rawMSN, rawPart := query.Get("_HLS_msn"), query.Get("_HLS_part")
+	if rawMSN == "" {
+		if rawPart != "" {
+			return 0, 0, false, fmt.Errorf("_HLS_part requires _HLS_msn")
+		}
+		return 0, 0, false, nil
+	}
+	msn, err := strconv.ParseInt(rawMSN, 10, 64)
+	if err != nil || msn < 0 {
+		return 0, 0, false, fmt.Errorf("invalid _HLS_msn")
+	}
+	part := -1
+	if rawPart != "" {
+		part, err = strconv.Atoi(rawPart)
+		if err != nil || part < 0 {
+			return 0, 0, false, fmt.Errorf("invalid _HLS_part")
+		}
+	}
+	return msn, part, true, nil
+}
+
+// parseTimeShift 读取 dvr 参数，取值为 1/true 时返回时移播放列表。
+func parseTimeShift(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("dvr")
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid dvr")
+	}
+	return v, nil
+}
+
+// resolvePlayOptions 读取 qn、protocol、format、codec 参数，缺省时使用配置默认值。
+func (s *Server) resolvePlayOptions(r *http.Request) (bili.PlayOptions, error) {
+	query := r.URL.Query()
+	opts := s.defaultPlayOptions()
+	if raw := query.Get("qn"); raw != "" {
+		qn, err := strconv.Atoi(raw)
+		if err != nil || qn <= 0 {
+			return bili.PlayOptions{}, fmt.Errorf("invalid qn")
+		}
+		// 归一到已知档位，避免任意 qn 各自占用刷新器、轮询器与时移采集。
+		opts.Qn = bili.SnapQn(qn)
+	}
+	if v := query.Get("protocol"); v != "" {
+		opts.Protocol = strings.ToLower(v)
+	}
+	if v := query.Get("format"); v != "" {
+		opts.Format = strings.ToLower(v)
+	}
+	if v := query.Get("codec"); v != "" {
+		opts.Codec = strings.ToLower(v)
+	}
+	if err := opts.Validate(); err != nil {
+		return bili.PlayOptions{}, err
+	}
+	return opts, nil
+}
+
+// inspectRoomState 查询房间状态，并将短号归一为长号写入 RoomID，后续缓存与日志均以长号为键。
 func (s *Server) inspectRoomState(ctx context.Context, roomID string) (streamState, int) {
-	status, err := s.biliClient.FetchRoomStatus(ctx, roomID)
+	status, err := s.statuses.Get(ctx, roomID)
 	if err != nil {
-		return streamState{RoomID: roomID, State: "error", Message: "获取直播状态失败"}, http.StatusBadGateway
+		return streamState{RoomID: roomID, RequestedID: roomID, State: "error", Message: "获取直播状态失败"}, http.StatusBadGateway
 	}
 
-	state := streamState{RoomID: roomID, LiveStatus: status.LiveStatus}
+	state := streamState{
+		RoomID:      canonicalRoomID(roomID, status),
+		ShortID:     status.ShortID,
+		RequestedID: roomID,
+		LiveStatus:  status.LiveStatus,
+	}
 	if status.IsLocked {
 		state.State = "locked"
 		state.Message = "直播间已封禁"
@@ -407,23 +973,41 @@ func (s *Server) inspectRoomState(ctx context.Context, roomID string) (streamSta
 	return state, http.StatusOK
 }
 
-func (s *Server) inspectStreamState(ctx context.Context, roomID string) (streamState, int) {
+func (s *Server) inspectStreamState(ctx context.Context, roomID string, opts bili.PlayOptions) (streamState, int) {
 	state, code := s.inspectRoomState(ctx, roomID)
 	if code != http.StatusOK {
 		return state, code
 	}
+	roomID = state.RoomID
 
-	originBase, err := s.biliClient.FetchPlayURL(ctx, roomID)
-	if err != nil || originBase == "" {
-		state.State = "waiting"
-		state.Message = "等待加载"
-		return state, http.StatusAccepted
+	if info, err := s.resolvers.Get(ctx, roomID, opts); err == nil {
+		state.Qn = info.Qn
+		state.Qualities = info.Qualities
+		state.Protocol = info.Protocol
+		state.Format = info.Format
+		state.Codec = info.Codec
 	}
-
-	data, status, err := s.origin.Get(ctx, originBase)
-	if err != nil || status != http.StatusOK || len(data) == 0 {
-		state.State = "loading"
-		state.Message = "加载中"
+	_, err := s.playlistSnapshot(ctx, roomID, opts)
+	health, tracked := s.resolvers.Health(roomID, opts)
+	if tracked {
+		state.Resolver = newResolverState(health)
+		state.Stale = health.Stale(time.Now())
+	}
+	if err != nil {
+		switch {
+		case health.Waiting():
+			state.State = "waiting"
+			state.Message = "等待播放地址"
+		case state.Stale:
+			state.State = "stale"
+			state.Message = "播放地址刷新失败，正在重试"
+		case errors.Is(err, stream.ErrSourceUnavailable):
+			state.State = "waiting"
+			state.Message = "等待加载"
+		default:
+			state.State = "loading"
+			state.Message = "加载中"
+		}
 		return state, http.StatusAccepted
 	}
 	state.State = "ready"
@@ -431,11 +1015,86 @@ func (s *Server) inspectStreamState(ctx context.Context, roomID string) (streamS
 	return state, http.StatusOK
 }
 
+// canonicalRoomID 返回 room_init 给出的长号，缺失时回退请求值。
+func canonicalRoomID(requested string, status bili.RoomStatus) string {
+	if status.RoomID > 0 {
+		return strconv.Itoa(status.RoomID)
+	}
+	return requested
+}
+
+// playURLSource 返回房间播放地址来源，统一由刷新器注册表提供缓存地址。
+func (s *Server) playURLSource(roomID string, opts bili.PlayOptions) stream.Source {
+	return func(ctx context.Context) (string, error) {
+		info, err := s.resolvers.Get(ctx, roomID, opts)
+		return info.URL, err
+	}
+}
+
+// writeLiveError 按直播播放列表的获取错误与播放地址刷新状态写入响应：等待首个地址或使用旧地址时附带 Retry-After。
+func (s *Server) writeLiveError(w http.ResponseWriter, r *http.Request, roomID string, opts bili.PlayOptions, err error) {
+	health, _ := s.resolvers.Health(roomID, opts)
+	if s.logger != nil {
+		fields := append(
+			[]any{"room_id", roomID, "options", opts.String(), "path", r.URL.Path, "failures", health.ConsecutiveFailures, "error", err},
+			requestFields(r)...,
+		)
+		s.logger.Error("fetch m3u8 failed", fields...)
+	}
+	switch {
+	case errors.Is(err, origin.ErrDenied):
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+	case errors.Is(err, stream.ErrTooManyRooms):
+		http.Error(w, "too many rooms", http.StatusServiceUnavailable)
+	case errors.Is(err, remux.ErrUnsupportedCodec):
+		http.Error(w, "hls unavailable, use /live.flv", http.StatusNotAcceptable)
+	case health.Waiting():
+		setRetryAfter(w, health, time.Now())
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("等待播放地址"))
+	case health.Stale(time.Now()):
+		setRetryAfter(w, health, time.Now())
+		http.Error(w, "play url stale, refreshing", http.StatusServiceUnavailable)
+	case errors.Is(err, stream.ErrSourceUnavailable):
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("等待加载"))
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("加载中"))
+	}
+}
+
+// playlistSnapshot 返回房间最新的源播放列表；房间只有 FLV 地址时改由转封装会话生成 HLS 播放列表。
+// 注册表拒绝房间或尚无播放地址时直接返回错误，不启动后台轮询。
+func (s *Server) playlistSnapshot(ctx context.Context, roomID string, opts bili.PlayOptions) (*stream.Snapshot, error) {
+	info, err := s.resolvers.Get(ctx, roomID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if info.Protocol == bili.ProtocolStream {
+		// 沿用已解析的 FLV 地址，会话键与 /live.flv 一致以共用上游连接。
+		return s.remux.Snapshot(ctx, pollerKey(roomID, flvOptions(opts)), s.playURLSource(roomID, opts))
+	}
+	return s.pollers.Snapshot(ctx, pollerKey(roomID, opts), s.playURLSource(roomID, opts))
+}
+
+// flvOptions 将播放偏好限定为 HTTP-FLV，使 /live.flv 与转封装共享同一上游连接。
+func flvOptions(opts bili.PlayOptions) bili.PlayOptions {
+	opts.Protocol, opts.Format = bili.ProtocolStream, bili.FormatFLV
+	return opts
+}
+
+// pollerKey 生成房间与播放偏好对应的轮询器键。
+func pollerKey(roomID string, opts bili.PlayOptions) string {
+	return roomID + ":" + opts.String()
+}
+
 // setCors 统一跨域响应头，避免 CDN 命中时缺失。
 func (s *Server) setCors(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag")
 }
 
 // requestFields 采集回源链路相关字段用于日志分析。
@@ -457,6 +1116,7 @@ func requestFields(r *http.Request) []any {
 	fields = appendField(fields, "x_cache_status", r.Header.Get("X-Cache-Status"))
 	fields = appendField(fields, "x_forwarded_proto", r.Header.Get("X-Forwarded-Proto"))
 	fields = appendField(fields, "cdn_request_id", r.Header.Get("X-Request-ID"))
+	fields = append(fields, trace.LogFields(r.Context())...)
 	return fields
 }
 
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PinkTide/internal/bili"
+	"PinkTide/internal/metrics"
+	"PinkTide/internal/origin"
+	"PinkTide/internal/rewriter"
+	"PinkTide/internal/segment"
+	"PinkTide/internal/stream"
+	"PinkTide/internal/urlsign"
+)
+
+func TestFMP4PlaylistThroughSegment(t *testing.T) {
+	initData := append([]byte{0, 0, 0, 24, 'f', 't', 'y', 'p'}, bytes.Repeat([]byte{1}, 16)...)
+	mediaData := append([]byte{0, 0, 0, 24, 'm', 'o', 'o', 'f'}, bytes.Repeat([]byte{2}, 64)...)
+	var initHits atomic.Int32
+	originSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live/h100.m4s":
+			initHits.Add(1)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(initData)
+		case "/live/101.m4s":
+			_, _ = w.Write(mediaData)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer originSrv.Close()
+
+	signer, err := urlsign.New([]urlsign.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("signer init failed: %v", err)
+	}
+	rw, err := rewriter.New("https://cdn.example.com", signer)
+	if err != nil {
+		t.Fatalf("rewriter init failed: %v", err)
+	}
+	s := &Server{
+		rewriter:   rw,
+		signer:     signer,
+		segFetcher: segment.NewFetcher(origin.NewClient(time.Second, nil), segment.NewCache(1<<20, time.Minute)),
+	}
+
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:101\n" +
+		"#EXT-X-MAP:URI=\"h100.m4s\"\n" +
+		"#EXTINF:1.000,\n" +
+		"101.m4s\n"
+	rewritten, err := rw.Rewrite(playlist, originSrv.URL+"/live/index.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+
+	var initURL, mediaURL string
+	for _, line := range strings.Split(rewritten, "\n") {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:URI=\""):
+			initURL = strings.TrimSuffix(strings.TrimPrefix(line, "#EXT-X-MAP:URI=\""), "\"")
+		case strings.HasPrefix(line, "https://cdn.example.com/seg?"):
+			mediaURL = line
+		}
+	}
+	if initURL == "" || mediaURL == "" {
+		t.Fatalf("segment urls not rewritten:\n%s", rewritten)
+	}
+
+	serve := func(link string) *httptest.ResponseRecorder {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("parse link failed: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		s.handleSegment(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := serve(initURL)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected init status: %d %s", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "video/mp4" {
+			t.Fatalf("unexpected init content type: %s", got)
+		}
+		if got, _ := io.ReadAll(rec.Body); !bytes.Equal(got, initData) {
+			t.Fatalf("unexpected init body: %v", got)
+		}
+	}
+	if n := initHits.Load(); n != 1 {
+		t.Fatalf("expected init segment to be cached, got %d origin hits", n)
+	}
+
+	bytesBefore := metrics.SegmentBytes.Value()
+	rec := serve(mediaURL)
+	if got := metrics.SegmentBytes.Value() - bytesBefore; got != float64(len(mediaData)) {
+		t.Fatalf("expected %d segment bytes counted, got %v", len(mediaData), got)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected media status: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "video/iso.segment" {
+		t.Fatalf("unexpected media content type: %s", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=31536000" {
+		t.Fatalf("unexpected cache control: %s", got)
+	}
+	if got, _ := io.ReadAll(rec.Body); !bytes.Equal(got, mediaData) {
+		t.Fatalf("unexpected media body: %v", got)
+	}
+}
+
+func TestSegmentRangeRequests(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	originSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "seg.ts", time.Time{}, bytes.NewReader(data))
+	}))
+	defer originSrv.Close()
+
+	signer, err := urlsign.New([]urlsign.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("signer init failed: %v", err)
+	}
+	rw, err := rewriter.New("https://cdn.example.com", signer)
+	if err != nil {
+		t.Fatalf("rewriter init failed: %v", err)
+	}
+	s := &Server{
+		rewriter:   rw,
+		signer:     signer,
+		segFetcher: segment.NewFetcher(origin.NewClient(time.Second, nil), segment.NewCache(1<<20, time.Minute)),
+	}
+	rewritten, err := rw.Rewrite("#EXTM3U\n#EXTINF:1.000,\nseg.ts\n", originSrv.URL+"/live/index.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	link, err := url.Parse(strings.Split(rewritten, "\n")[2])
+	if err != nil {
+		t.Fatalf("parse link failed: %v", err)
+	}
+	serve := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, link.RequestURI(), nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.handleSegment(rec, req)
+		return rec
+	}
+
+	// 未缓存时区间请求透传给源站。
+	rec := serve(http.MethodGet, map[string]string{"Range": "bytes=10-19"})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != "bytes 10-19/100" || rec.Body.String() != string(data[10:20]) {
+		t.Fatalf("unexpected passthrough range: %d %q %q", rec.Code, rec.Header().Get("Content-Range"), rec.Body.String())
+	}
+
+	rec = serve(http.MethodHead, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "100" || rec.Body.Len() != 0 {
+		t.Fatalf("unexpected head response: %d %q %d", rec.Code, rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected etag")
+	}
+
+	// HEAD 之后切片已缓存，区间在本地切分。
+	rec = serve(http.MethodGet, map[string]string{"Range": "bytes=90-"})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != "bytes 90-99/100" || rec.Body.String() != string(data[90:]) {
+		t.Fatalf("unexpected cached range: %d %q %q", rec.Code, rec.Header().Get("Content-Range"), rec.Body.String())
+	}
+	rec = serve(http.MethodHead, map[string]string{"Range": "bytes=0-9"})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Length") != "10" || rec.Body.Len() != 0 {
+		t.Fatalf("unexpected head range: %d %q %d", rec.Code, rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+
+	if rec = serve(http.MethodGet, map[string]string{"Range": "bytes=0-1,5-6"}); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected multi-range to be rejected, got %d", rec.Code)
+	}
+
+	rec = serve(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Fatalf("expected full body for stale If-Range, got %d %d bytes", rec.Code, rec.Body.Len())
+	}
+	rec = serve(http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != string(data[:10]) {
+		t.Fatalf("expected range for matching If-Range, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMasterPlaylistVariantProxy(t *testing.T) {
+	originSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live/1080/index.m3u8":
+			_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1.000,\nseg-1.ts\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer originSrv.Close()
+
+	signer, err := urlsign.New([]urlsign.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("signer init failed: %v", err)
+	}
+	rw, err := rewriter.New("https://cdn.example.com", signer)
+	if err != nil {
+		t.Fatalf("rewriter init failed: %v", err)
+	}
+	pollers := stream.NewPollerHub(origin.NewClient(time.Second, nil), time.Minute, nil)
+	defer pollers.Close()
+	s := &Server{rewriter: rw, signer: signer, pollers: pollers}
+
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2000000\n1080/index.m3u8\n"
+	rewritten, err := rw.Rewrite(master, originSrv.URL+"/live/master.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	variant := strings.Split(rewritten, "\n")[2]
+	if !strings.HasPrefix(variant, "https://cdn.example.com/playlist?") {
+		t.Fatalf("variant not proxied: %s", variant)
+	}
+
+	u, err := url.Parse(variant)
+	if err != nil {
+		t.Fatalf("parse variant failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+	req.Host = "cdn.example.com"
+	s.handlePlaylist(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=1" {
+		t.Fatalf("unexpected cache control: %s", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Fatalf("unexpected content type: %s", got)
+	}
+	want := "https://cdn.example.com/seg?payload=" + base64.URLEncoding.EncodeToString([]byte(originSrv.URL+"/live/1080/seg-1.ts"))
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("variant segments not rewritten:\n%s", rec.Body.String())
+	}
+
+	tampered := strings.Replace(u.RequestURI(), "sig=", "sig=x", 1)
+	rec = httptest.NewRecorder()
+	s.handlePlaylist(rec, httptest.NewRequest(http.MethodGet, tampered, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected tampered link to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestVariantPlaylistReloadsAfterSigningTTL(t *testing.T) {
+	originSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1.000,\nseg-1.ts\n"))
+	}))
+	defer originSrv.Close()
+
+	signer, err := urlsign.New([]urlsign.Key{{ID: "k1", Secret: []byte("secret")}}, time.Second)
+	if err != nil {
+		t.Fatalf("signer init failed: %v", err)
+	}
+	rw, err := rewriter.New("https://cdn.example.com", signer)
+	if err != nil {
+		t.Fatalf("rewriter init failed: %v", err)
+	}
+	pollers := stream.NewPollerHub(origin.NewClient(time.Second, nil), time.Minute, nil)
+	defer pollers.Close()
+	s := &Server{rewriter: rw, signer: signer, pollers: pollers}
+
+	master, err := rw.Rewrite("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nv/index.m3u8\n", originSrv.URL+"/live/master.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	media, err := rw.Rewrite("#EXTM3U\n#EXTINF:1.000,\nseg.ts\n", originSrv.URL+"/live/v/index.m3u8", "cdn.example.com")
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	variant, err := url.Parse(strings.Split(master, "\n")[2])
+	if err != nil {
+		t.Fatalf("parse variant failed: %v", err)
+	}
+	seg, err := url.Parse(strings.Split(media, "\n")[2])
+	if err != nil {
+		t.Fatalf("parse segment failed: %v", err)
+	}
+
+	// 等到同时签发的切片地址过期。
+	exp, err := strconv.ParseInt(seg.Query().Get("exp"), 10, 64)
+	if err != nil {
+		t.Fatalf("parse exp failed: %v", err)
+	}
+	time.Sleep(time.Until(time.Unix(exp, 0)) + 50*time.Millisecond)
+	rec := httptest.NewRecorder()
+	s.handleSegment(rec, httptest.NewRequest(http.MethodGet, seg.RequestURI(), nil))
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expected segment link to expire, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handlePlaylist(rec, httptest.NewRequest(http.MethodGet, variant.RequestURI(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected variant reload after signing ttl, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 不过期的 /playlist 签名不能改作 /seg 地址。
+	forged := strings.Replace(variant.RequestURI(), "/playlist?", "/seg?", 1) + "&kind=" + rewriter.KindPlaylist
+	rec = httptest.NewRecorder()
+	s.handleSegment(rec, httptest.NewRequest(http.MethodGet, forged, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected playlist signature to be rejected on /seg, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.handleSegment(rec, httptest.NewRequest(http.MethodGet, strings.Replace(variant.RequestURI(), "/playlist?", "/seg?", 1), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected playlist signature to be rejected on /seg, got %d", rec.Code)
+	}
+}
+
+func TestPlaylistSnapshotRespectsRoomLimit(t *testing.T) {
+	policy := origin.NewPolicy(nil, []string{"denied.invalid"}, false)
+	client := bili.NewClient(origin.NewClient(time.Second, nil).WithPolicy(policy))
+	resolvers := stream.NewRegistry(client, time.Minute, time.Minute, 1, nil)
+	defer resolvers.Close()
+	resolvers.Pin("1", bili.PlayOptions{})
+
+	// pollers 为空：若仍启动轮询器会直接 panic。
+	s := &Server{resolvers: resolvers}
+	if _, err := s.playlistSnapshot(context.Background(), "2", bili.PlayOptions{}); !errors.Is(err, stream.ErrTooManyRooms) {
+		t.Fatalf("expected ErrTooManyRooms, got %v", err)
+	}
+}
+
+func TestLiveErrorReportsWaitingForFailedRoom(t *testing.T) {
+	policy := origin.NewPolicy(nil, []string{"denied.invalid"}, false)
+	client := bili.NewClient(origin.NewClient(time.Second, nil).WithPolicy(policy))
+	resolvers := stream.NewRegistry(client, 100*time.Millisecond, time.Minute, 1, nil)
+	defer resolvers.Close()
+
+	// 未固定的房间首次解析失败后，解析器保留到下次重试，处理器据此返回等待响应。
+	s := &Server{resolvers: resolvers}
+	if _, err := s.playlistSnapshot(context.Background(), "2", bili.PlayOptions{}); err == nil {
+		t.Fatal("expected resolve failure")
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/live.m3u8?room_id=2", nil)
+	s.writeLiveError(rec, req, "2", bili.PlayOptions{}, stream.ErrSourceUnavailable)
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "等待播放地址" {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := resolvers.Health("2", bili.PlayOptions{}); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed resolver was not released after its retry time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseBlockingReload(t *testing.T) {
+	cases := []struct {
+		query    string
+		msn      int64
+		part     int
+		blocking bool
+		wantErr  bool
+	}{
+		{query: ""},
+		{query: "_HLS_msn=12", msn: 12, part: -1, blocking: true},
+		{query: "_HLS_msn=12&_HLS_part=3", msn: 12, part: 3, blocking: true},
+		{query: "_HLS_part=3", wantErr: true},
+		{query: "_HLS_msn=-1", wantErr: true},
+		{query: "_HLS_msn=1&_HLS_part=x", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			msn, part, blocking, err := parseBlockingReload(httptest.NewRequest(http.MethodGet, "/live.m3u8?"+tc.query, nil))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && (msn != tc.msn || part != tc.part || blocking != tc.blocking) {
+				t.Fatalf("unexpected result: %d %d %v", msn, part, blocking)
+			}
+		})
+	}
+}
+
+func TestParseClipTime(t *testing.T) {
+	got, err := parseClipTime("1767225600.5")
+	if err != nil || !got.Equal(time.Date(2026, 1, 1, 0, 0, 0, 500*int(time.Millisecond), time.UTC)) {
+		t.Fatalf("unexpected unix time %v: %v", got, err)
+	}
+	got, err = parseClipTime("2026-01-01T08:00:00+08:00")
+	if err != nil || !got.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected rfc3339 time %v: %v", got, err)
+	}
+	for _, raw := range []string{"", "-1", "yesterday"} {
+		if _, err := parseClipTime(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"PinkTide/internal/stream"
+)
+
+// resolverState 为 /api/status 返回的播放地址刷新器状态。
+type resolverState struct {
+	LastSuccess         string  `json:"last_success,omitempty"`
+	LastError           string  `json:"last_error,omitempty"`
+	LastErrorAt         string  `json:"last_error_at,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	URLAge              float64 `json:"url_age,omitempty"`
+	Expires             string  `json:"expires,omitempty"`
+	NextRefresh         string  `json:"next_refresh,omitempty"`
+}
+
+// newResolverState 将刷新器健康状态转换为接口字段，时间统一为 UTC RFC 3339。
+func newResolverState(h stream.Health) *resolverState {
+	state := &resolverState{
+		LastSuccess:         formatTime(h.LastSuccess),
+		LastErrorAt:         formatTime(h.LastErrorAt),
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		URLAge:              math.Round(h.URLAge.Seconds()),
+		Expires:             formatTime(h.Expires),
+		NextRefresh:         formatTime(h.NextRefresh),
+	}
+	if h.LastError != nil {
+		state.LastError = h.LastError.Error()
+	}
+	return state
+}
+
+// formatTime 格式化时间，零值返回空串。
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// setRetryAfter 按刷新器下次重试时间设置 Retry-After（秒，至少为 1）。
+func setRetryAfter(w http.ResponseWriter, h stream.Health, now time.Time) {
+	if h.NextRefresh.IsZero() {
+		return
+	}
+	seconds := int(math.Ceil(h.NextRefresh.Sub(now).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"PinkTide/internal/remux"
+	"PinkTide/internal/rewriter"
+)
+
+const (
+	// initSegmentTTL 为初始化段的缓存时长，同一初始化段在整场直播中被所有新加入的播放器反复请求。
+	initSegmentTTL = time.Hour
+	// partSegmentTTL 为 LL-HLS 分片的缓存时长，分片只在播放列表末尾短暂出现。
+	partSegmentTTL = time.Minute
+)
+
+// segmentCacheControl 返回切片的 CDN 缓存头，分片使用短缓存，其余切片地址不变可长期缓存。
+func segmentCacheControl(kind string) string {
+	if kind == rewriter.KindPart {
+		return fmt.Sprintf("public, max-age=%d", int(partSegmentTTL/time.Second))
+	}
+	return "public, max-age=31536000"
+}
+
+// segmentTypes 按扩展名映射切片类型。
+var segmentTypes = map[string]string{
+	".ts":   "video/mp2t",
+	".mp4":  "video/mp4",
+	".m4s":  "video/iso.segment",
+	".m4v":  "video/mp4",
+	".cmfv": "video/mp4",
+	".m4a":  "audio/mp4",
+	".cmfa": "audio/mp4",
+	".aac":  "audio/aac",
+	".mp3":  "audio/mpeg",
+	".vtt":  "text/vtt",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".key":  "application/octet-stream",
+}
+
+// segmentContentType 推断切片类型：优先采用源站声明的具体类型，其次按扩展名，
+// 再按已获取的首部字节识别，均无法判断时初始化段按 MP4、其余按 TS 处理。
+func segmentContentType(target, declared string, head []byte, kind string) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && !genericContentType(mediaType) {
+		return declared
+	}
+	if u, err := url.Parse(target); err == nil {
+		if contentType, ok := segmentTypes[strings.ToLower(path.Ext(u.Path))]; ok {
+			if kind == rewriter.KindInit && contentType == "video/iso.segment" {
+				return "video/mp4"
+			}
+			return contentType
+		}
+	}
+	if contentType := sniffSegment(head); contentType != "" {
+		return contentType
+	}
+	if kind == rewriter.KindInit {
+		return "video/mp4"
+	}
+	return "video/mp2t"
+}
+
+// genericContentType 判断源站是否只返回了无法说明内容的通用类型。
+func genericContentType(mediaType string) bool {
+	switch mediaType {
+	case "application/octet-stream", "binary/octet-stream", "application/binary", "text/plain":
+		return true
+	}
+	return false
+}
+
+// sniffSegment 根据 TS 同步字节与 MP4 box 类型识别切片内容。
+func sniffSegment(head []byte) string {
+	if len(head) >= 8 {
+		switch string(head[4:8]) {
+		case "ftyp", "moov":
+			return "video/mp4"
+		case "styp", "moof", "sidx", "emsg", "prft":
+			return "video/iso.segment"
+		}
+	}
+	if len(head) >= 189 && head[0] == 0x47 && head[188] == 0x47 {
+		return "video/mp2t"
+	}
+	if bytes.HasPrefix(head, []byte("ID3")) || bytes.HasPrefix(head, []byte{0xFF, 0xF1}) || bytes.HasPrefix(head, []byte{0xFF, 0xF9}) {
+		return "audio/aac"
+	}
+	return ""
+}
+
+// segmentData 下载完整切片，供录制与时移采集使用：经由切片缓存回源，转封装切片直接从内存读取。
+func (s *Server) segmentData(ctx context.Context, target string) ([]byte, error) {
+	if strings.HasPrefix(target, remux.Scheme+"://") {
+		data, ok := s.remux.Segment(target)
+		if !ok {
+			return nil, fmt.Errorf("remux segment expired")
+		}
+		return data, nil
+	}
+	data, _, err := s.segFetcher.Fetch(ctx, target, 0)
+	return data, err
+}
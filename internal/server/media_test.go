@@ -0,0 +1,32 @@
+package server
+
+import "testing"
+
+func TestSegmentContentType(t *testing.T) {
+	moof := []byte{0, 0, 0, 16, 'm', 'o', 'o', 'f'}
+	cases := []struct {
+		name     string
+		target   string
+		declared string
+		head     []byte
+		kind     string
+		want     string
+	}{
+		{name: "declared", target: "https://o.example.com/a.bin", declared: "video/mp4", want: "video/mp4"},
+		{name: "generic declared falls back to extension", target: "https://o.example.com/a.ts?x=1", declared: "application/octet-stream", want: "video/mp2t"},
+		{name: "m4s", target: "https://o.example.com/1.m4s", want: "video/iso.segment"},
+		{name: "init m4s", target: "https://o.example.com/h1.m4s", kind: "init", want: "video/mp4"},
+		{name: "init mp4", target: "https://o.example.com/init.mp4", kind: "init", want: "video/mp4"},
+		{name: "aac", target: "https://o.example.com/a.AAC", want: "audio/aac"},
+		{name: "sniff fmp4", target: "https://o.example.com/seg", head: moof, want: "video/iso.segment"},
+		{name: "unknown init", target: "https://o.example.com/seg", kind: "init", want: "video/mp4"},
+		{name: "unknown", target: "https://o.example.com/seg", want: "video/mp2t"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := segmentContentType(tc.target, tc.declared, tc.head, tc.kind); got != tc.want {
+				t.Fatalf("unexpected content type: %s", got)
+			}
+		})
+	}
+}
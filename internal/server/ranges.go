@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// errMultiRange 表示请求包含多个区间，切片接口不提供 multipart 响应。
+	errMultiRange = errors.New("multiple ranges not supported")
+	// errUnsatisfiableRange 表示区间超出内容长度。
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+	// errIgnoredRange 表示 Range 头无法识别，按规范忽略并返回完整内容。
+	errIgnoredRange = errors.New("range ignored")
+)
+
+// byteRange 描述单个闭区间 [start, start+length)。
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange 生成 Content-Range 响应头。
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// checkRangeHeader 在未知内容长度时做语法检查，用于提前拒绝多区间请求。
+func checkRangeHeader(header string) error {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return errIgnoredRange
+	}
+	if strings.Contains(spec, ",") {
+		return errMultiRange
+	}
+	start, end, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || (start == "" && end == "") {
+		return errIgnoredRange
+	}
+	return nil
+}
+
+// parseRange 按内容长度解析单区间 Range 头，支持 a-b、a- 与 -n 三种形式。
+func parseRange(header string, size int64) (byteRange, error) {
+	if err := checkRangeHeader(header); err != nil {
+		return byteRange{}, err
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(header), "bytes="))
+	startRaw, endRaw, _ := strings.Cut(spec, "-")
+	startRaw = strings.TrimSpace(startRaw)
+	endRaw = strings.TrimSpace(endRaw)
+
+	if startRaw == "" {
+		suffix, err := strconv.ParseInt(endRaw, 10, 64)
+		if err != nil || suffix < 0 {
+			return byteRange{}, errIgnoredRange
+		}
+		if suffix == 0 || size == 0 {
+			return byteRange{}, errUnsatisfiableRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return byteRange{start: size - suffix, length: suffix}, nil
+	}
+
+	start, err := strconv.ParseInt(startRaw, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, errIgnoredRange
+	}
+	if start >= size {
+		return byteRange{}, errUnsatisfiableRange
+	}
+	end := size - 1
+	if endRaw != "" {
+		end, err = strconv.ParseInt(endRaw, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, errIgnoredRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return byteRange{start: start, length: end - start + 1}, nil
+}
+
+// segmentETag 以回源地址生成强校验值，直播切片地址与内容一一对应。
+func segmentETag(target string) string {
+	sum := sha1.Sum([]byte(target))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// ifRangeMatches 判断 If-Range 是否允许按区间响应，仅接受与当前 ETag 一致的强校验值。
+func ifRangeMatches(ifRange, etag string) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	return ifRange == etag
+}
+
+// writeRange 基于完整切片数据输出区间响应，HEAD 请求只返回响应头。
+func writeRange(w http.ResponseWriter, r *http.Request, data []byte, rangeHeader string) (int64, error) {
+	size := int64(len(data))
+	br, err := parseRange(rangeHeader, size)
+	if errors.Is(err, errIgnoredRange) {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return 0, nil
+		}
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return 0, err
+	}
+
+	w.Header().Set("Content-Range", br.contentRange(size))
+	w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return 0, nil
+	}
+	n, err := w.Write(data[br.start : br.start+br.length])
+	return int64(n), err
+}
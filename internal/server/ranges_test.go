@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		size    int64
+		want    byteRange
+		wantErr error
+	}{
+		{name: "closed", header: "bytes=0-99", size: 1000, want: byteRange{start: 0, length: 100}},
+		{name: "open end", header: "bytes=900-", size: 1000, want: byteRange{start: 900, length: 100}},
+		{name: "suffix", header: "bytes=-10", size: 1000, want: byteRange{start: 990, length: 10}},
+		{name: "suffix larger than size", header: "bytes=-5000", size: 1000, want: byteRange{start: 0, length: 1000}},
+		{name: "end clamped", header: "bytes=10-5000", size: 1000, want: byteRange{start: 10, length: 990}},
+		{name: "start beyond size", header: "bytes=1000-", size: 1000, wantErr: errUnsatisfiableRange},
+		{name: "multi", header: "bytes=0-1,5-6", size: 1000, wantErr: errMultiRange},
+		{name: "other unit", header: "items=0-1", size: 1000, wantErr: errIgnoredRange},
+		{name: "reversed", header: "bytes=9-1", size: 1000, wantErr: errIgnoredRange},
+		{name: "empty spec", header: "bytes=-", size: 1000, wantErr: errIgnoredRange},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRange(tc.header, tc.size)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected range: %+v", got)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	etag := segmentETag("https://origin.example.com/seg.ts")
+	if !ifRangeMatches("", etag) {
+		t.Fatalf("expected empty If-Range to match")
+	}
+	if !ifRangeMatches(etag, etag) {
+		t.Fatalf("expected same etag to match")
+	}
+	if ifRangeMatches(`"other"`, etag) {
+		t.Fatalf("expected different etag to mismatch")
+	}
+	if ifRangeMatches("Wed, 21 Oct 2015 07:28:00 GMT", etag) {
+		t.Fatalf("expected date validator to mismatch")
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"PinkTide/internal/stream"
+)
+
+// recordStatus 为录制器查询房间是否直播中，返回归一后的长号。
+func (s *Server) recordStatus(ctx context.Context, roomID string) (string, bool, error) {
+	state, code := s.inspectRoomState(ctx, roomID)
+	if code == http.StatusBadGateway {
+		return "", false, fmt.Errorf("room status unavailable")
+	}
+	return state.RoomID, code == http.StatusOK, nil
+}
+
+// recordPlaylist 按默认播放偏好返回房间的源播放列表，与观众共享轮询器或转封装会话。
+func (s *Server) recordPlaylist(ctx context.Context, roomID string) (*stream.Snapshot, error) {
+	return s.playlistSnapshot(ctx, roomID, s.defaultPlayOptions())
+}
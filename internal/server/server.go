@@ -9,11 +9,17 @@ import (
 
 	"PinkTide/internal/bili"
 	"PinkTide/internal/config"
+	"PinkTide/internal/dvr"
+	"PinkTide/internal/flv"
 	"PinkTide/internal/origin"
+	"PinkTide/internal/recorder"
+	"PinkTide/internal/remux"
 	"PinkTide/internal/rewriter"
 	"PinkTide/internal/segment"
 	"PinkTide/internal/stream"
 	"PinkTide/internal/tlsutil"
+	"PinkTide/internal/trace"
+	"PinkTide/internal/urlsign"
 )
 
 var BuildVersion = "dev"
@@ -23,10 +29,19 @@ type Server struct {
 	cfg        config.Config
 	httpServer *http.Server
 	origin     *origin.Client
+	media      *origin.Client
 	biliClient *bili.Client
+	statuses   *bili.StatusCache
 	rewriter   *rewriter.Rewriter
-	resolver   *stream.Resolver
+	resolvers  *stream.Registry
+	pollers    *stream.PollerHub
+	flvHub     *flv.Hub
+	remux      *remux.Hub
+	recorder   *recorder.Recorder
+	dvr        *dvr.Store
+	tracer     *trace.Tracer
 	segFetcher *segment.Fetcher
+	signer     *urlsign.Signer
 	serveMux   *http.ServeMux
 	logger     *slog.Logger
 	certFile   string
@@ -41,16 +56,23 @@ func New(cfg config.Config, logger *slog.Logger) (*Server, error) {
 		"Referer":    "https://live.bilibili.com/",
 	}
 	originClient := origin.NewClient(cfg.RequestTimeout, headers)
-	rewriterInstance, err := rewriter.New(cfg.CDNPublicURL)
+	signer, err := newSigner(cfg, logger)
 	if err != nil {
 		return nil, err
 	}
-	biliClient := bili.NewClient(originClient)
-	var resolver *stream.Resolver
-	if cfg.BiliRoomID != "" {
-		resolver = stream.NewResolver(biliClient, cfg.BiliRoomID, cfg.RefreshInterval, logger)
+	rewriterInstance, err := rewriter.New(cfg.CDNPublicURL, signer)
+	if err != nil {
+		return nil, err
 	}
-	fetcher := segment.NewFetcher(originClient)
+	policy := origin.NewPolicy(cfg.OriginSchemes, cfg.OriginHosts, cfg.OriginBlockPrivate)
+	mediaClient := originClient.WithPolicy(policy)
+	biliClient := bili.NewClient(originClient)
+	resolvers := stream.NewRegistry(biliClient, cfg.RefreshInterval, cfg.ResolverIdleTimeout, cfg.ResolverMaxRooms, logger)
+	fetcher := segment.NewFetcher(mediaClient, segment.NewCache(cfg.SegmentCacheSize, cfg.SegmentCacheTTL))
+	flvHub := flv.NewHub(mediaClient.Streaming(), logger)
+	flvHub.OnReject(resolvers.Invalidate)
+	pollers := stream.NewPollerHub(mediaClient, cfg.PollerIdleTimeout, logger)
+	pollers.OnReject(resolvers.Invalidate)
 
 	mux := http.NewServeMux()
 	certFile := ""
@@ -66,15 +88,29 @@ func New(cfg config.Config, logger *slog.Logger) (*Server, error) {
 	srv := &Server{
 		cfg:        cfg,
 		origin:     originClient,
+		media:      mediaClient,
 		biliClient: biliClient,
+		statuses:   bili.NewStatusCache(biliClient, cfg.StatusLiveTTL, cfg.StatusOfflineTTL),
 		rewriter:   rewriterInstance,
-		resolver:   resolver,
+		resolvers:  resolvers,
+		pollers:    pollers,
+		flvHub:     flvHub,
+		remux:      remux.NewHub(flvHub, cfg.PollerIdleTimeout, logger),
 		segFetcher: fetcher,
+		signer:     signer,
 		serveMux:   mux,
 		logger:     logger,
 		certFile:   certFile,
 		keyFile:    keyFile,
 	}
+	srv.recorder = recorder.New(cfg.RecordDir, cfg.RecordInterval, srv.recordStatus, srv.recordPlaylist, srv.segmentData, logger)
+	if cfg.DVRWindow > 0 {
+		srv.dvr = dvr.New(cfg.DVRDir, cfg.DVRWindow, cfg.DVRRetention, srv.segmentData, logger)
+	}
+	if srv.tracer, err = newTracer(cfg); err != nil {
+		return nil, err
+	}
+	trace.SetDefault(srv.tracer)
 	srv.registerRoutes()
 	srv.httpServer = &http.Server{
 		Addr:         cfg.ListenAddr,
@@ -92,10 +128,51 @@ func New(cfg config.Config, logger *slog.Logger) (*Server, error) {
 	return srv, nil
 }
 
+// newTracer 按配置创建追踪器，未配置导出器时仍生成追踪标识用于日志关联。
+func newTracer(cfg config.Config) (*trace.Tracer, error) {
+	switch cfg.TraceExporter {
+	case "otlp":
+		return trace.NewTracer(trace.NewOTLPExporter(cfg.TraceOTLPEndpoint), cfg.TraceSampleRatio), nil
+	case "file":
+		exporter, err := trace.NewFileExporter(cfg.TraceFile)
+		if err != nil {
+			return nil, err
+		}
+		return trace.NewTracer(exporter, cfg.TraceSampleRatio), nil
+	default:
+		return trace.NewTracer(nil, 0), nil
+	}
+}
+
+// newSigner 按配置创建切片签名器，未配置密钥时生成进程内临时密钥。
+func newSigner(cfg config.Config, logger *slog.Logger) (*urlsign.Signer, error) {
+	keys, err := urlsign.ParseKeys(cfg.SigningKeys)
+	if err != nil {
+		return nil, fmt.Errorf("parse PT_SIGNING_KEYS failed: %w", err)
+	}
+	if len(keys) == 0 {
+		key, err := urlsign.RandomKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if logger != nil {
+			logger.Warn("signing keys not configured, using ephemeral key")
+		}
+	}
+	return urlsign.New(keys, cfg.SigningTTL)
+}
+
 // Start 启动 HTTP 服务并在必要时启动后台刷新任务。
 func (s *Server) Start(ctx context.Context) error {
-	if s.resolver != nil {
-		go s.resolver.Start(ctx)
+	if s.cfg.BiliRoomID != "" {
+		go s.pinDefaultRoom(ctx)
+	}
+	if len(s.cfg.RecordRooms) > 0 {
+		s.recorder.Start(s.cfg.RecordRooms)
+		if s.logger != nil {
+			s.logger.Info("recording enabled", "rooms", s.cfg.RecordRooms, "dir", s.cfg.RecordDir)
+		}
 	}
 	if s.logger != nil {
 		s.logger.Info("server start", "addr", s.cfg.ListenAddr, "tls_mode", s.cfg.TLSMode)
@@ -130,6 +207,30 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
+// pinDefaultRoom 将默认房间归一为长号后预热刷新器，状态查询失败时使用原始配置值。
+func (s *Server) pinDefaultRoom(ctx context.Context) {
+	roomID := s.cfg.BiliRoomID
+	status, err := s.statuses.Get(ctx, roomID)
+	if err != nil {
+		if s.logger != nil {
+			s.logger.Warn("resolve default room failed", "room_id", roomID, "error", err)
+		}
+	} else {
+		roomID = canonicalRoomID(roomID, status)
+	}
+	s.resolvers.Pin(roomID, s.defaultPlayOptions())
+}
+
+// defaultPlayOptions 返回配置中的默认播放偏好，清晰度与请求参数一样归一到已知档位。
+func (s *Server) defaultPlayOptions() bili.PlayOptions {
+	return bili.PlayOptions{
+		Qn:       bili.SnapQn(s.cfg.DefaultQn),
+		Protocol: s.cfg.DefaultProtocol,
+		Format:   s.cfg.DefaultFormat,
+		Codec:    s.cfg.DefaultCodec,
+	}
+}
+
 // Shutdown 尝试在超时内关闭服务并释放资源。
 func (s *Server) Shutdown(ctx context.Context) error {
 	if s.httpServer == nil {
@@ -138,8 +239,21 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if s.logger != nil {
 		s.logger.Info("server shutdown")
 	}
+	s.recorder.Close()
+	if s.dvr != nil {
+		s.dvr.Close()
+	}
+	s.pollers.Close()
+	s.remux.Close()
+	s.flvHub.Close()
+	s.resolvers.Close()
 	if s.redirect != nil {
 		_ = s.redirect.Shutdown(ctx)
 	}
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	// 请求全部结束后再导出剩余 span，避免遗漏关闭过程中的请求。
+	if traceErr := s.tracer.Shutdown(ctx); traceErr != nil && s.logger != nil {
+		s.logger.Warn("trace export shutdown failed", "error", traceErr)
+	}
+	return err
 }
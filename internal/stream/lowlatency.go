@@ -0,0 +1,59 @@
+package stream
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"PinkTide/internal/playlist"
+)
+
+// minPartPollInterval 限制低延迟播放列表在不支持阻塞刷新时的最短轮询间隔。
+const minPartPollInterval = 100 * time.Millisecond
+
+// playlistPosition 描述媒体播放列表的低延迟能力与当前进度。
+type playlistPosition struct {
+	partTarget time.Duration
+	canBlock   bool
+	nextMSN    int64
+	nextPart   int
+}
+
+// parsePosition 读取 EXT-X-PART-INF、EXT-X-SERVER-CONTROL 与分段序号，
+// nextMSN 为首个未完成切片的序号，nextPart 为其已发布的分片数量。
+func parsePosition(pl *playlist.Playlist) playlistPosition {
+	pos := playlistPosition{
+		partTarget: pl.PartTarget,
+		nextMSN:    pl.MediaSequence + int64(len(pl.Segments)),
+		nextPart:   pl.PendingParts(),
+	}
+	if tag := pl.Tag("#EXT-X-SERVER-CONTROL"); tag != nil {
+		v, _ := tag.Attr("CAN-BLOCK-RELOAD")
+		pos.canBlock = v == "YES"
+	}
+	return pos
+}
+
+// contains 判断播放列表是否已包含指定切片或分片，part 小于 0 表示只要求完整切片。
+func (pos playlistPosition) contains(msn int64, part int) bool {
+	if msn < pos.nextMSN {
+		return true
+	}
+	return part >= 0 && msn == pos.nextMSN && part < pos.nextPart
+}
+
+// blockingURL 为源站地址附加阻塞刷新参数，请求下一个分片出现后再返回。
+func (pos playlistPosition) blockingURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	// 追加而非重新编码查询串，保持源站鉴权参数的原始顺序与编码。
+	directives := "_HLS_msn=" + strconv.FormatInt(pos.nextMSN, 10) + "&_HLS_part=" + strconv.Itoa(pos.nextPart)
+	if u.RawQuery == "" {
+		u.RawQuery = directives
+	} else {
+		u.RawQuery += "&" + directives
+	}
+	return u.String()
+}
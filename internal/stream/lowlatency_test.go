@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"PinkTide/internal/origin"
+	"PinkTide/internal/playlist"
+)
+
+func TestParsePosition(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.0\n" +
+		"#EXT-X-PART-INF:PART-TARGET=0.334\n" +
+		"#EXT-X-MEDIA-SEQUENCE:100\n" +
+		"#EXT-X-PART:DURATION=0.334,URI=\"100.0.m4s\"\n" +
+		"#EXTINF:2.000,\n" +
+		"100.m4s\n" +
+		"#EXT-X-PART:DURATION=0.334,URI=\"101.0.m4s\"\n" +
+		"#EXT-X-PART:DURATION=0.334,URI=\"101.1.m4s\"\n" +
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"101.2.m4s\"\n"
+	pos := parsePosition(playlist.Parse(content))
+	if !pos.canBlock || pos.partTarget != 334*time.Millisecond {
+		t.Fatalf("unexpected low latency info: %+v", pos)
+	}
+	if pos.nextMSN != 101 || pos.nextPart != 2 {
+		t.Fatalf("unexpected position: %+v", pos)
+	}
+
+	cases := []struct {
+		msn  int64
+		part int
+		want bool
+	}{
+		{msn: 100, part: -1, want: true},
+		{msn: 101, part: -1, want: false},
+		{msn: 101, part: 1, want: true},
+		{msn: 101, part: 2, want: false},
+		{msn: 102, part: 0, want: false},
+	}
+	for _, tc := range cases {
+		if got := pos.contains(tc.msn, tc.part); got != tc.want {
+			t.Fatalf("contains(%d, %d) = %v", tc.msn, tc.part, got)
+		}
+	}
+
+	if got := pos.blockingURL("https://o.example.com/live.m3u8?expires=1&sign=a%2Bb"); got != "https://o.example.com/live.m3u8?expires=1&sign=a%2Bb&_HLS_msn=101&_HLS_part=2" {
+		t.Fatalf("unexpected blocking url: %s", got)
+	}
+	if parsePosition(playlist.Parse("#EXTM3U\n#EXTINF:2,\na.ts\n")).partTarget != 0 {
+		t.Fatalf("expected regular playlist to have no part target")
+	}
+}
+
+func TestPollerHubAwaitBlockingReload(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		parts = 1
+	)
+	render := func() string {
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:1\n")
+		b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n#EXT-X-PART-INF:PART-TARGET=0.2\n")
+		b.WriteString("#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:1.000,\n10.m4s\n")
+		for i := 0; i < parts; i++ {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=0.2,URI=\"11.%d.m4s\"\n", i)
+		}
+		return b.String()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if raw := r.URL.Query().Get("_HLS_part"); raw != "" {
+			want, _ := strconv.Atoi(raw)
+			// 模拟源站挂起阻塞请求直到下一个分片生成。
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			if parts <= want {
+				parts = want + 1
+			}
+			mu.Unlock()
+		}
+		mu.Lock()
+		body := render()
+		mu.Unlock()
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	hub := NewPollerHub(origin.NewClient(time.Second, nil), time.Second, nil)
+	defer hub.Close()
+	source := func(context.Context) (string, error) { return srv.URL + "/live.m3u8", nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	snap, err := hub.Await(ctx, "room", source, 11, 3)
+	if err != nil {
+		t.Fatalf("await failed: %v", err)
+	}
+	if !snap.Contains(11, 3) || !strings.Contains(snap.Content, "11.3.m4s") {
+		t.Fatalf("unexpected snapshot:\n%s", snap.Content)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	if _, err := hub.Await(short, "room", source, 20, 0); err == nil {
+		t.Fatalf("expected await to time out")
+	}
+}
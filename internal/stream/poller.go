@@ -0,0 +1,358 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"PinkTide/internal/origin"
+	"PinkTide/internal/playlist"
+	"PinkTide/internal/trace"
+)
+
+const (
+	// defaultPollInterval 在播放列表未给出 EXT-X-TARGETDURATION 时使用。
+	defaultPollInterval = 2 * time.Second
+	// minPollInterval 限制最短轮询间隔，避免异常播放列表导致高频回源。
+	minPollInterval = 500 * time.Millisecond
+	// staleIntervals 为拉取失败后仍可继续提供旧播放列表的轮询周期数。
+	staleIntervals = 3
+)
+
+// ErrSourceUnavailable 表示暂未取得房间的播放地址。
+var ErrSourceUnavailable = errors.New("play url unavailable")
+
+// Source 返回房间当前的播放列表地址。
+type Source func(ctx context.Context) (string, error)
+
+// Snapshot 为一次成功拉取的播放列表，按请求 Host 缓存重写结果。
+type Snapshot struct {
+	Content        string
+	OriginBase     string
+	FetchedAt      time.Time
+	TargetDuration time.Duration
+
+	position  playlistPosition
+	mu        sync.Mutex
+	rewritten map[string]string
+}
+
+// LowLatency 判断源站是否提供 LL-HLS 分片。
+func (s *Snapshot) LowLatency() bool {
+	return s.position.partTarget > 0
+}
+
+// NextMSN 返回首个未完成切片的媒体序号。
+func (s *Snapshot) NextMSN() int64 {
+	return s.position.nextMSN
+}
+
+// Contains 判断播放列表是否已包含指定切片或分片，part 小于 0 表示只要求完整切片。
+func (s *Snapshot) Contains(msn int64, part int) bool {
+	return s.position.contains(msn, part)
+}
+
+// Rewritten 返回指定 Host 的重写结果，首次调用时通过 rewrite 生成并缓存。
+func (s *Snapshot) Rewritten(host string, rewrite func(content, originBase string) (string, error)) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.rewritten[host]; ok {
+		return v, nil
+	}
+	v, err := rewrite(s.Content, s.OriginBase)
+	if err != nil {
+		return "", err
+	}
+	if s.rewritten == nil {
+		s.rewritten = make(map[string]string)
+	}
+	s.rewritten[host] = v
+	return v, nil
+}
+
+// PollerHub 为每个活跃房间维护一个后台轮询器，空闲超时后自动停止。
+type PollerHub struct {
+	client      *origin.Client
+	idleTimeout time.Duration
+	logger      *slog.Logger
+	reject      func(target string)
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	pollers     map[string]*poller
+}
+
+// NewPollerHub 创建轮询器集合，client 用于拉取源站播放列表。
+func NewPollerHub(client *origin.Client, idleTimeout time.Duration, logger *slog.Logger) *PollerHub {
+	ctx, cancel := context.WithCancel(trace.Background(context.Background()))
+	return &PollerHub{
+		client:      client,
+		idleTimeout: idleTimeout,
+		logger:      logger,
+		ctx:         ctx,
+		cancel:      cancel,
+		pollers:     make(map[string]*poller),
+	}
+}
+
+// OnReject 设置源站以 403/404 拒绝播放地址时的回调，需在首次访问前调用。
+func (h *PollerHub) OnReject(fn func(target string)) {
+	h.reject = fn
+}
+
+// Snapshot 返回 key 对应房间的最新播放列表，首次访问时启动轮询并等待首个结果。
+func (h *PollerHub) Snapshot(ctx context.Context, key string, source Source) (*Snapshot, error) {
+	ctx, span := trace.Start(ctx, "poller.Snapshot", trace.KindInternal)
+	defer span.End()
+	span.SetAttr("poller.key", key)
+	p := h.acquire(ctx, key, source)
+	snap, err := p.wait(ctx)
+	span.SetError(err)
+	return snap, err
+}
+
+// Await 处理 LL-HLS 阻塞刷新：等待 key 对应播放列表包含指定切片或分片后返回，超时由 ctx 控制。
+func (h *PollerHub) Await(ctx context.Context, key string, source Source, msn int64, part int) (snap *Snapshot, err error) {
+	ctx, span := trace.Start(ctx, "poller.Await", trace.KindInternal)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttr("poller.key", key)
+	span.SetAttr("hls.msn", msn)
+	span.SetAttr("hls.part", part)
+	p := h.acquire(ctx, key, source)
+	for {
+		p.mu.Lock()
+		updated := p.updated
+		p.mu.Unlock()
+
+		snap, err = p.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if snap.Contains(msn, part) {
+			return snap, nil
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close 停止全部轮询器。
+func (h *PollerHub) Close() {
+	h.cancel()
+}
+
+// acquire 获取或创建轮询器并刷新访问时间，新建时首轮拉取挂在 ctx 的 span 之下。
+func (h *PollerHub) acquire(ctx context.Context, key string, source Source) *poller {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.pollers[key]
+	if !ok {
+		p = &poller{
+			key:     key,
+			source:  source,
+			client:  h.client,
+			logger:  h.logger,
+			reject:  h.reject,
+			parent:  trace.SpanContextFromContext(ctx),
+			updated: make(chan struct{}),
+		}
+		h.pollers[key] = p
+		go h.run(p)
+	}
+	p.touch()
+	return p
+}
+
+// run 按 EXT-X-TARGETDURATION 节奏轮询，空闲超时或服务关闭时退出。
+func (h *PollerHub) run(p *poller) {
+	if h.logger != nil {
+		h.logger.Debug("playlist poller started", "key", p.key)
+	}
+	// 首轮拉取关联触发它的请求，使等待首个播放列表的耗时出现在该请求的追踪中。
+	ctx := trace.ContextWithRemote(h.ctx, p.parent)
+	for {
+		interval := p.poll(ctx)
+		ctx = h.ctx
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-h.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		h.mu.Lock()
+		if time.Since(p.lastAccess()) > h.idleTimeout {
+			delete(h.pollers, p.key)
+			h.mu.Unlock()
+			if h.logger != nil {
+				h.logger.Debug("playlist poller stopped", "key", p.key)
+			}
+			return
+		}
+		h.mu.Unlock()
+	}
+}
+
+// poller 保存单个房间的最新播放列表与拉取状态。
+type poller struct {
+	key    string
+	source Source
+	client *origin.Client
+	logger *slog.Logger
+	reject func(target string)
+	parent trace.SpanContext
+
+	mu       sync.Mutex
+	current  *Snapshot
+	err      error
+	success  time.Time
+	attempts int
+	interval time.Duration
+	accessed time.Time
+	updated  chan struct{}
+}
+
+// touch 记录最近一次访问时间。
+func (p *poller) touch() {
+	p.mu.Lock()
+	p.accessed = time.Now()
+	p.mu.Unlock()
+}
+
+// lastAccess 返回最近一次访问时间。
+func (p *poller) lastAccess() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.accessed
+}
+
+// wait 返回可用的播放列表；尚无结果时等待首轮拉取，连续失败超过容忍周期时返回错误。
+func (p *poller) wait(ctx context.Context) (*Snapshot, error) {
+	for {
+		p.mu.Lock()
+		snap, err, attempts, updated := p.current, p.err, p.attempts, p.updated
+		success, interval := p.success, p.interval
+		p.mu.Unlock()
+
+		if err == nil && snap != nil {
+			return snap, nil
+		}
+		if err != nil && snap != nil && time.Since(success) < staleIntervals*interval {
+			return snap, nil
+		}
+		if attempts > 0 && err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// poll 执行一次拉取并返回下次轮询间隔。
+func (p *poller) poll(ctx context.Context) time.Duration {
+	p.mu.Lock()
+	previous := p.current
+	p.mu.Unlock()
+	snap, err := p.fetch(ctx, previous)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	p.err = err
+	interval := p.interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	if err == nil {
+		unchanged := p.current != nil && p.current.Content == snap.Content && p.current.OriginBase == snap.OriginBase
+		if !unchanged {
+			p.current = snap
+		}
+		p.success = snap.FetchedAt
+		interval = snap.TargetDuration
+		p.interval = interval
+		if pos := snap.position; pos.partTarget > 0 {
+			close(p.updated)
+			p.updated = make(chan struct{})
+			// 源站支持阻塞刷新时立即发起下一次阻塞请求，否则按分片目标时长轮询。
+			if pos.canBlock && !unchanged {
+				return 0
+			}
+			return max(pos.partTarget, minPartPollInterval)
+		}
+		// 播放列表未变化时按半个目标时长重试，与 HLS 客户端刷新策略一致。
+		if unchanged {
+			interval /= 2
+		}
+	} else if p.logger != nil {
+		fields := append([]any{"key", p.key, "error", err}, trace.LogFields(ctx)...)
+		p.logger.Warn("playlist poll failed", fields...)
+	}
+	close(p.updated)
+	p.updated = make(chan struct{})
+	if interval < minPollInterval {
+		interval = minPollInterval
+	}
+	return interval
+}
+
+// fetch 获取播放地址并拉取源站播放列表，上一轮结果支持阻塞刷新时附加 _HLS_msn/_HLS_part。
+func (p *poller) fetch(ctx context.Context, previous *Snapshot) (*Snapshot, error) {
+	originBase, err := p.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
+	}
+	if originBase == "" {
+		return nil, ErrSourceUnavailable
+	}
+	target := originBase
+	if previous != nil && previous.position.canBlock && previous.position.partTarget > 0 {
+		target = previous.position.blockingURL(originBase)
+	}
+	data, status, err := p.client.Get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		if IsRejected(status) && p.reject != nil {
+			p.reject(originBase)
+		}
+		return nil, fmt.Errorf("origin status %d", status)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty playlist")
+	}
+	content := string(data)
+	pl := playlist.Parse(content)
+	return &Snapshot{
+		Content:        content,
+		OriginBase:     originBase,
+		FetchedAt:      time.Now(),
+		TargetDuration: targetDuration(pl),
+		position:       parsePosition(pl),
+	}, nil
+}
+
+// targetDuration 返回 EXT-X-TARGETDURATION，缺失时返回默认间隔。
+func targetDuration(pl *playlist.Playlist) time.Duration {
+	if pl.TargetDuration > 0 {
+		return pl.TargetDuration
+	}
+	return defaultPollInterval
+}
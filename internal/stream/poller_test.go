@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PinkTide/internal/origin"
+	"PinkTide/internal/playlist"
+)
+
+func TestPollerHubServesFromMemory(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\nseg-1.ts\n"))
+	}))
+	defer srv.Close()
+
+	hub := NewPollerHub(origin.NewClient(time.Second, nil), 50*time.Millisecond, nil)
+	defer hub.Close()
+	source := func(context.Context) (string, error) { return srv.URL + "/live.m3u8", nil }
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		snap, err := hub.Snapshot(ctx, "room", source)
+		if err != nil {
+			t.Fatalf("snapshot failed: %v", err)
+		}
+		if snap.OriginBase != srv.URL+"/live.m3u8" {
+			t.Fatalf("unexpected origin base: %s", snap.OriginBase)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("expected a single origin fetch, got %d", n)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		hub.mu.Lock()
+		remaining := len(hub.pollers)
+		hub.mu.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected idle poller to stop")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPollerHubSourceUnavailable(t *testing.T) {
+	hub := NewPollerHub(origin.NewClient(time.Second, nil), time.Second, nil)
+	defer hub.Close()
+	source := func(context.Context) (string, error) { return "", nil }
+
+	_, err := hub.Snapshot(context.Background(), "room", source)
+	if !errors.Is(err, ErrSourceUnavailable) {
+		t.Fatalf("expected source unavailable, got %v", err)
+	}
+}
+
+func TestPollerHubReportsRejectedURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	hub := NewPollerHub(origin.NewClient(time.Second, nil), time.Second, nil)
+	defer hub.Close()
+	rejected := make(chan string, 1)
+	hub.OnReject(func(target string) {
+		select {
+		case rejected <- target:
+		default:
+		}
+	})
+	source := func(context.Context) (string, error) { return srv.URL + "/live.m3u8", nil }
+
+	if _, err := hub.Snapshot(context.Background(), "room", source); err == nil {
+		t.Fatal("expected origin error")
+	}
+	select {
+	case target := <-rejected:
+		if target != srv.URL+"/live.m3u8" {
+			t.Fatalf("unexpected rejected url: %s", target)
+		}
+	default:
+		t.Fatal("expected rejected url to be reported")
+	}
+}
+
+func TestTargetDuration(t *testing.T) {
+	if got := targetDuration(playlist.Parse("#EXTM3U\r\n#EXT-X-TARGETDURATION:4\r\n")); got != 4*time.Second {
+		t.Fatalf("unexpected duration: %s", got)
+	}
+	if got := targetDuration(playlist.Parse("#EXTM3U\n")); got != defaultPollInterval {
+		t.Fatalf("unexpected default: %s", got)
+	}
+}
@@ -0,0 +1,221 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"PinkTide/internal/bili"
+	"PinkTide/internal/trace"
+)
+
+// ErrTooManyRooms 表示同时解析的房间数已达上限。
+var ErrTooManyRooms = errors.New("too many active rooms")
+
+// Registry 按房间号与播放偏好维护播放地址刷新器：首次访问时创建，后续复用，空闲超时后回收；
+// 房间数上限按不同房间号计数，同一房间的多组播放偏好只占一个名额。
+type Registry struct {
+	client          *bili.Client
+	refreshInterval time.Duration
+	idleTimeout     time.Duration
+	maxRooms        int
+	logger          *slog.Logger
+	ctx             context.Context
+	cancel          context.CancelFunc
+	mu              sync.Mutex
+	entries         map[registryKey]*registryEntry
+	rooms           map[string]int
+}
+
+// registryKey 唯一标识一个房间的某组播放偏好。
+type registryKey struct {
+	roomID string
+	opts   bili.PlayOptions
+}
+
+// registryEntry 记录刷新器及其生命周期信息。
+type registryEntry struct {
+	resolver   *Resolver
+	cancel     context.CancelFunc
+	pinned     bool
+	releasing  bool
+	lastAccess time.Time
+}
+
+// NewRegistry 创建刷新器注册表，maxRooms 不大于 0 时不限制房间数。
+func NewRegistry(client *bili.Client, refreshInterval, idleTimeout time.Duration, maxRooms int, logger *slog.Logger) *Registry {
+	ctx, cancel := context.WithCancel(trace.Background(context.Background()))
+	reg := &Registry{
+		client:          client,
+		refreshInterval: refreshInterval,
+		idleTimeout:     idleTimeout,
+		maxRooms:        maxRooms,
+		logger:          logger,
+		ctx:             ctx,
+		cancel:          cancel,
+		entries:         make(map[registryKey]*registryEntry),
+		rooms:           make(map[string]int),
+	}
+	go reg.janitor()
+	return reg
+}
+
+// Pin 为房间创建常驻刷新器，不参与空闲回收，用于默认房间预热。
+func (g *Registry) Pin(roomID string, opts bili.PlayOptions) {
+	key := registryKey{roomID: roomID, opts: opts}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if entry, ok := g.entries[key]; ok {
+		entry.pinned = true
+		return
+	}
+	g.startLocked(key, trace.SpanContext{}).pinned = true
+}
+
+// Get 返回房间当前播放地址，房间首次访问时创建刷新器并等待首轮结果；
+// 非常驻房间尚未取得过地址时，刷新器保留至下次重试时间后移除：期间的访问可读到等待状态与重试时间，
+// 之后不再占用房间名额，下次访问重新拉取。常驻房间的刷新器保留并按退避节奏在后台重试。
+func (g *Registry) Get(ctx context.Context, roomID string, opts bili.PlayOptions) (bili.PlayInfo, error) {
+	ctx, span := trace.Start(ctx, "resolver.Get", trace.KindInternal)
+	defer span.End()
+	span.SetAttr("room_id", roomID)
+	span.SetAttr("play_options", opts.String())
+	key := registryKey{roomID: roomID, opts: opts}
+	resolver, err := g.acquire(ctx, key)
+	if err != nil {
+		span.SetError(err)
+		return bili.PlayInfo{}, err
+	}
+	info, err := resolver.Wait(ctx)
+	if err != nil && ctx.Err() == nil {
+		g.release(key, resolver)
+	}
+	span.SetError(err)
+	return info, err
+}
+
+// Health 返回房间刷新器的健康状态，刷新器不存在时返回 false。
+func (g *Registry) Health(roomID string, opts bili.PlayOptions) (Health, bool) {
+	g.mu.Lock()
+	entry, ok := g.entries[registryKey{roomID: roomID, opts: opts}]
+	g.mu.Unlock()
+	if !ok {
+		return Health{}, false
+	}
+	return entry.resolver.Health(), true
+}
+
+// Invalidate 通知当前地址为 target 的刷新器立即刷新，用于源站以 403/404 拒绝已缓存的地址。
+func (g *Registry) Invalidate(target string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, entry := range g.entries {
+		if entry.resolver.Invalidate(target) && g.logger != nil {
+			g.logger.Info("play url rejected by origin, refreshing", "room_id", key.roomID, "options", key.opts.String())
+		}
+	}
+}
+
+// IsRejected 判断源站状态码是否表示播放地址已失效：403 为鉴权过期，404 为流已迁移。
+func IsRejected(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusNotFound
+}
+
+// Close 停止全部刷新器。
+func (g *Registry) Close() {
+	g.cancel()
+}
+
+// acquire 获取或创建刷新器，超出房间上限时返回 ErrTooManyRooms；新建时首轮刷新挂在 ctx 的 span 之下。
+func (g *Registry) acquire(ctx context.Context, key registryKey) (*Resolver, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if entry, ok := g.entries[key]; ok {
+		entry.lastAccess = time.Now()
+		return entry.resolver, nil
+	}
+	if g.maxRooms > 0 && g.rooms[key.roomID] == 0 && len(g.rooms) >= g.maxRooms {
+		return nil, ErrTooManyRooms
+	}
+	return g.startLocked(key, trace.SpanContextFromContext(ctx)).resolver, nil
+}
+
+// release 在下次重试时间移除从未取得地址的刷新器，常驻房间、已有地址或已被替换的刷新器保持不变。
+func (g *Registry) release(key registryKey, resolver *Resolver) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, ok := g.entries[key]
+	if !ok || entry.pinned || entry.releasing || entry.resolver != resolver || resolver.Get() != "" {
+		return
+	}
+	entry.releasing = true
+	time.AfterFunc(time.Until(resolver.Health().NextRefresh), func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.entries[key] == entry && !entry.pinned && resolver.Get() == "" {
+			g.removeLocked(key, entry)
+		}
+	})
+}
+
+// startLocked 创建并启动刷新器，parent 为首轮刷新的父级 span，调用方需持有锁。
+func (g *Registry) startLocked(key registryKey, parent trace.SpanContext) *registryEntry {
+	ctx, cancel := context.WithCancel(g.ctx)
+	entry := &registryEntry{
+		resolver:   NewResolver(g.client, key.roomID, key.opts, g.refreshInterval, g.logger),
+		cancel:     cancel,
+		lastAccess: time.Now(),
+	}
+	g.entries[key] = entry
+	g.rooms[key.roomID]++
+	go entry.resolver.start(ctx, parent)
+	if g.logger != nil {
+		g.logger.Debug("resolver created", "room_id", key.roomID, "options", key.opts.String(), "rooms", len(g.rooms))
+	}
+	return entry
+}
+
+// removeLocked 停止并移除刷新器，房间的最后一个刷新器移除后释放房间名额，调用方需持有锁。
+func (g *Registry) removeLocked(key registryKey, entry *registryEntry) {
+	entry.cancel()
+	delete(g.entries, key)
+	if g.rooms[key.roomID]--; g.rooms[key.roomID] <= 0 {
+		delete(g.rooms, key.roomID)
+	}
+}
+
+// janitor 定期回收空闲刷新器。
+func (g *Registry) janitor() {
+	interval := g.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+			g.evictIdle(time.Now())
+		}
+	}
+}
+
+// evictIdle 停止超过空闲时间的非常驻刷新器。
+func (g *Registry) evictIdle(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, entry := range g.entries {
+		if entry.pinned || now.Sub(entry.lastAccess) < g.idleTimeout {
+			continue
+		}
+		g.removeLocked(key, entry)
+		if g.logger != nil {
+			g.logger.Debug("resolver evicted", "room_id", key.roomID, "options", key.opts.String(), "rooms", len(g.rooms))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"PinkTide/internal/bili"
+	"PinkTide/internal/origin"
+)
+
+// deniedClient 返回被回源策略拒绝全部请求的接口客户端，刷新器首轮即失败且不发起网络请求。
+func deniedClient() *bili.Client {
+	policy := origin.NewPolicy(nil, []string{"denied.invalid"}, false)
+	return bili.NewClient(origin.NewClient(time.Second, nil).WithPolicy(policy))
+}
+
+func TestRegistryLimitsDistinctRooms(t *testing.T) {
+	reg := NewRegistry(deniedClient(), time.Minute, time.Minute, 1, nil)
+	defer reg.Close()
+	ctx := context.Background()
+
+	if _, err := reg.acquire(ctx, registryKey{roomID: "1", opts: bili.PlayOptions{Qn: 10000}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reg.acquire(ctx, registryKey{roomID: "1", opts: bili.PlayOptions{Qn: 400}}); err != nil {
+		t.Fatalf("expected other options of the same room to share its slot: %v", err)
+	}
+	if _, err := reg.acquire(ctx, registryKey{roomID: "2", opts: bili.PlayOptions{Qn: 10000}}); !errors.Is(err, ErrTooManyRooms) {
+		t.Fatalf("expected ErrTooManyRooms, got %v", err)
+	}
+
+	reg.evictIdle(time.Now().Add(time.Hour))
+	if _, err := reg.acquire(ctx, registryKey{roomID: "2", opts: bili.PlayOptions{Qn: 10000}}); err != nil {
+		t.Fatalf("expected evicted room to free its slot: %v", err)
+	}
+}
+
+func TestRegistryReleasesFailedResolver(t *testing.T) {
+	// 刷新间隔限制退避上限，使首次重试在 100ms 内。
+	reg := NewRegistry(deniedClient(), 100*time.Millisecond, time.Minute, 1, nil)
+	defer reg.Close()
+	ctx := context.Background()
+
+	if _, err := reg.Get(ctx, "1", bili.PlayOptions{}); !errors.Is(err, origin.ErrDenied) {
+		t.Fatalf("expected denied error, got %v", err)
+	}
+	h, ok := reg.Health("1", bili.PlayOptions{})
+	if !ok || !h.Waiting() || h.NextRefresh.IsZero() {
+		t.Fatalf("expected failed resolver to report waiting until its retry: %+v, %v", h, ok)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := reg.Health("1", bili.PlayOptions{}); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected failed resolver to be released after its retry time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := reg.Get(ctx, "2", bili.PlayOptions{}); errors.Is(err, ErrTooManyRooms) {
+		t.Fatal("expected failed room not to hold a slot")
+	}
+
+	reg.Pin("3", bili.PlayOptions{})
+	_, _ = reg.Get(ctx, "3", bili.PlayOptions{})
+	time.Sleep(200 * time.Millisecond)
+	if h, ok := reg.Health("3", bili.PlayOptions{}); !ok || !h.Waiting() {
+		t.Fatalf("expected pinned resolver to keep retrying: %+v, %v", h, ok)
+	}
+}
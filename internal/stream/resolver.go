@@ -2,90 +2,286 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 	"time"
 
 	"PinkTide/internal/bili"
+	"PinkTide/internal/metrics"
+	"PinkTide/internal/trace"
 )
 
-// Resolver 负责定时刷新直播流地址并提供缓存读取。
+const (
+	// expiryMargin 为播放地址过期前提前刷新的余量。
+	expiryMargin = time.Minute
+	// minRefreshDelay 限制两次刷新的最短间隔，避免地址已过期或源站持续拒绝时高频调用接口。
+	minRefreshDelay = 10 * time.Second
+	// backoffBase 为刷新失败后首次重试的退避时长，此后逐次翻倍直至固定刷新间隔。
+	backoffBase = 2 * time.Second
+)
+
+// errEmptyPlayURL 表示接口成功返回但没有可用地址。
+var errEmptyPlayURL = errors.New("empty play url")
+
+// Health 为刷新器的健康状态，URLAge 为当前缓存地址距获取的时长，尚无地址时为 0。
+type Health struct {
+	HasURL              bool
+	LastSuccess         time.Time
+	LastError           error
+	LastErrorAt         time.Time
+	ConsecutiveFailures int
+	URLAge              time.Duration
+	Expires             time.Time
+	NextRefresh         time.Time
+}
+
+// Stale 判断是否正在使用旧地址：已有地址但最近的刷新失败，或地址已超过过期时间。
+func (h Health) Stale(now time.Time) bool {
+	if !h.HasURL {
+		return false
+	}
+	return h.ConsecutiveFailures > 0 || (!h.Expires.IsZero() && now.After(h.Expires))
+}
+
+// Waiting 判断是否仍在等待首个地址且已有失败记录。
+func (h Health) Waiting() bool {
+	return !h.HasURL && h.ConsecutiveFailures > 0
+}
+
+// Resolver 负责刷新直播流地址并提供缓存读取：地址携带过期时间时在过期前刷新，否则按固定间隔刷新。
 type Resolver struct {
 	client          *bili.Client
 	roomID          string
+	opts            bili.PlayOptions
 	refreshInterval time.Duration
-	cache           *stringCache
+	cache           *infoCache
 	logger          *slog.Logger
+	ready           chan struct{}
+	readyOnce       sync.Once
+	invalidated     chan struct{}
+	mu              sync.Mutex
+	lastErr         error
+	lastErrAt       time.Time
+	lastSuccess     time.Time
+	failures        int
+	refreshedAt     time.Time
+	nextAt          time.Time
 }
 
-// NewResolver 创建刷新器并注入日志，用于异常可观测。
-func NewResolver(client *bili.Client, roomID string, refreshInterval time.Duration, logger *slog.Logger) *Resolver {
+// NewResolver 创建刷新器并注入日志，用于异常可观测；opts 为清晰度与协议、封装、编码偏好。
+func NewResolver(client *bili.Client, roomID string, opts bili.PlayOptions, refreshInterval time.Duration, logger *slog.Logger) *Resolver {
 	return &Resolver{
 		client:          client,
 		roomID:          roomID,
+		opts:            opts,
 		refreshInterval: refreshInterval,
-		cache:           &stringCache{},
+		cache:           &infoCache{},
 		logger:          logger,
+		ready:           make(chan struct{}),
+		invalidated:     make(chan struct{}, 1),
 	}
 }
 
-// Start 启动定时刷新，ctx 取消后退出。
+// Start 启动刷新循环，ctx 取消后退出。
 func (r *Resolver) Start(ctx context.Context) {
-	r.refresh(ctx)
-	ticker := time.NewTicker(r.refreshInterval)
-	defer ticker.Stop()
+	r.start(ctx, trace.SpanContext{})
+}
 
+// start 启动刷新循环，首轮刷新以 parent 为父级 span，使触发创建的请求能看到获取播放地址的耗时。
+func (r *Resolver) start(ctx context.Context, parent trace.SpanContext) {
+	refreshCtx := trace.ContextWithRemote(ctx, parent)
 	for {
+		r.refresh(refreshCtx)
+		refreshCtx = ctx
+		r.mu.Lock()
+		delay := time.Until(r.nextAt)
+		r.mu.Unlock()
+		timer := time.NewTimer(delay)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return
-		case <-ticker.C:
-			r.refresh(ctx)
+		case <-timer.C:
+		case <-r.invalidated:
+			timer.Stop()
 		}
 	}
 }
 
+// Invalidate 在源站拒绝当前地址时请求立即刷新；target 不是当前地址或距上次刷新不足 minRefreshDelay 时忽略。
+func (r *Resolver) Invalidate(target string) bool {
+	if target == "" || r.cache.Get().URL != target {
+		return false
+	}
+	r.mu.Lock()
+	recent := time.Since(r.refreshedAt) < minRefreshDelay
+	r.mu.Unlock()
+	if recent {
+		return false
+	}
+	select {
+	case r.invalidated <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// schedule 按本轮结果记录下次刷新时间。
+func (r *Resolver) schedule() {
+	now := time.Now()
+	next := now.Add(r.nextRefresh(now))
+	r.mu.Lock()
+	r.nextAt = next
+	r.mu.Unlock()
+}
+
+// nextRefresh 计算距下次刷新的等待时间：刷新失败时按带抖动的指数退避重试，
+// 成功且地址携带过期时间时在过期前 expiryMargin 刷新（不短于 minRefreshDelay），否则使用固定刷新间隔。
+func (r *Resolver) nextRefresh(now time.Time) time.Duration {
+	r.mu.Lock()
+	failures := r.failures
+	r.mu.Unlock()
+	if failures > 0 {
+		return r.backoff(failures)
+	}
+
+	expires := r.cache.Get().Expires
+	if expires.IsZero() {
+		return r.refreshInterval
+	}
+	return max(expires.Sub(now)-expiryMargin, minRefreshDelay)
+}
+
+// backoff 返回第 failures 次连续失败后的重试等待时间，取 [d/2, d] 内的随机值，d 不超过固定刷新间隔。
+func (r *Resolver) backoff(failures int) time.Duration {
+	d := r.refreshInterval
+	if shift := failures - 1; shift < 30 {
+		d = min(backoffBase<<shift, r.refreshInterval)
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// Health 返回刷新器当前的健康状态。
+func (r *Resolver) Health() Health {
+	info := r.cache.Get()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := Health{
+		HasURL:              info.URL != "",
+		LastSuccess:         r.lastSuccess,
+		LastError:           r.lastErr,
+		LastErrorAt:         r.lastErrAt,
+		ConsecutiveFailures: r.failures,
+		Expires:             info.Expires,
+		NextRefresh:         r.nextAt,
+	}
+	if h.HasURL {
+		h.URLAge = time.Since(r.lastSuccess)
+	}
+	return h
+}
+
 // Get 返回当前缓存的播放地址。
 func (r *Resolver) Get() string {
+	return r.cache.Get().URL
+}
+
+// Info 返回当前缓存的播放信息。
+func (r *Resolver) Info() bili.PlayInfo {
 	return r.cache.Get()
 }
 
-// refresh 单次拉取并更新缓存，失败只记录日志。
+// Wait 等待首轮刷新完成并返回播放信息，首轮失败时返回失败原因。
+func (r *Resolver) Wait(ctx context.Context) (bili.PlayInfo, error) {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		return bili.PlayInfo{}, ctx.Err()
+	}
+	if info := r.cache.Get(); info.URL != "" {
+		return info, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastErr != nil {
+		return bili.PlayInfo{}, r.lastErr
+	}
+	return bili.PlayInfo{}, errEmptyPlayURL
+}
+
+// refresh 单次拉取并更新缓存与健康状态，失败时保留旧地址并记录日志。
+// 下次刷新时间在首轮结果公布前确定，使等待方读到的健康状态包含重试时间。
 func (r *Resolver) refresh(ctx context.Context) {
-	url, err := r.client.FetchPlayURL(ctx, r.roomID)
+	defer r.readyOnce.Do(func() { close(r.ready) })
+	defer r.schedule()
+	info, err := r.client.FetchPlayURL(ctx, r.roomID, r.opts)
+	if err == nil && info.URL == "" {
+		err = errEmptyPlayURL
+	}
+	now := time.Now()
+	r.mu.Lock()
+	r.refreshedAt = now
+	r.lastErr = err
 	if err != nil {
-		if r.logger != nil {
-			r.logger.Warn("fetch play url failed", "room_id", r.roomID, "error", err)
-		}
-		return
+		r.lastErrAt = now
+		r.failures++
+	} else {
+		r.lastSuccess = now
+		r.failures = 0
 	}
-	if url == "" {
+	failures := r.failures
+	r.mu.Unlock()
+	metrics.ResolverRefreshes.Inc(refreshOutcome(err))
+	if err != nil {
 		if r.logger != nil {
-			r.logger.Warn("empty play url", "room_id", r.roomID)
+			fields := append(
+				[]any{"room_id", r.roomID, "failures", failures, "stale", r.cache.Get().URL != "", "error", err},
+				trace.LogFields(ctx)...,
+			)
+			r.logger.Warn("fetch play url failed", fields...)
 		}
 		return
 	}
-	r.cache.Set(url)
+	r.cache.Set(info)
 	if r.logger != nil {
-		r.logger.Debug("play url updated", "room_id", r.roomID)
+		fields := append(
+			[]any{"room_id", r.roomID, "qn", info.Qn, "protocol", info.Protocol, "format", info.Format, "codec", info.Codec, "expires", info.Expires},
+			trace.LogFields(ctx)...,
+		)
+		r.logger.Debug("play url updated", fields...)
+	}
+}
+
+// refreshOutcome 将刷新结果转换为指标标签值。
+func refreshOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errEmptyPlayURL):
+		return "empty"
+	default:
+		return "error"
 	}
 }
 
-// stringCache 提供并发安全的字符串缓存。
-type stringCache struct {
+// infoCache 提供并发安全的播放信息缓存。
+type infoCache struct {
 	mu    sync.RWMutex
-	value string
+	value bili.PlayInfo
 }
 
 // Get 读取当前值。
-func (c *stringCache) Get() string {
+func (c *infoCache) Get() bili.PlayInfo {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.value
 }
 
 // Set 更新缓存值。
-func (c *stringCache) Set(v string) {
+func (c *infoCache) Set(v bili.PlayInfo) {
 	c.mu.Lock()
 	c.value = v
 	c.mu.Unlock()
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"PinkTide/internal/bili"
+)
+
+func TestResolverSchedulesBeforeExpiry(t *testing.T) {
+	now := time.Now()
+	r := NewResolver(nil, "1", bili.PlayOptions{}, 10*time.Minute, nil)
+	if d := r.nextRefresh(now); d != 10*time.Minute {
+		t.Fatalf("expected fixed interval without expiry, got %v", d)
+	}
+
+	r.cache.Set(bili.PlayInfo{URL: "https://cdn.example.com/live.m3u8", Expires: now.Add(time.Hour)})
+	if d := r.nextRefresh(now); d != time.Hour-expiryMargin {
+		t.Fatalf("expected refresh before expiry, got %v", d)
+	}
+	r.failures = 1
+	if d := r.nextRefresh(now); d < backoffBase/2 || d > backoffBase {
+		t.Fatalf("expected first backoff within [%v, %v], got %v", backoffBase/2, backoffBase, d)
+	}
+	r.failures = 3
+	if d := r.nextRefresh(now); d < 2*backoffBase || d > 4*backoffBase {
+		t.Fatalf("expected third backoff within [%v, %v], got %v", 2*backoffBase, 4*backoffBase, d)
+	}
+	r.failures = 40
+	if d := r.nextRefresh(now); d < 5*time.Minute || d > 10*time.Minute {
+		t.Fatalf("expected backoff capped by refresh interval, got %v", d)
+	}
+
+	r.failures = 0
+	r.cache.Set(bili.PlayInfo{URL: "https://cdn.example.com/live.m3u8", Expires: now.Add(30 * time.Second)})
+	if d := r.nextRefresh(now); d != minRefreshDelay {
+		t.Fatalf("expected minimum delay near expiry, got %v", d)
+	}
+}
+
+func TestResolverHealth(t *testing.T) {
+	now := time.Now()
+	r := NewResolver(nil, "1", bili.PlayOptions{}, 10*time.Minute, nil)
+	r.failures, r.lastErr = 2, errors.New("api status 500")
+	if h := r.Health(); !h.Waiting() || h.Stale(now) {
+		t.Fatalf("expected waiting without url: %+v", h)
+	}
+
+	r.cache.Set(bili.PlayInfo{URL: "https://cdn.example.com/live.m3u8", Expires: now.Add(time.Hour)})
+	r.lastSuccess = now.Add(-time.Minute)
+	if h := r.Health(); h.Waiting() || !h.Stale(now) || h.URLAge < time.Minute {
+		t.Fatalf("expected stale url after failures: %+v", h)
+	}
+
+	r.failures, r.lastErr = 0, nil
+	if h := r.Health(); h.Stale(now) || !h.Stale(now.Add(2*time.Hour)) {
+		t.Fatalf("expected staleness to follow expiry: %+v", h)
+	}
+}
+
+func TestResolverInvalidate(t *testing.T) {
+	r := NewResolver(nil, "1", bili.PlayOptions{}, 10*time.Minute, nil)
+	r.cache.Set(bili.PlayInfo{URL: "https://cdn.example.com/live.m3u8"})
+	if r.Invalidate("https://cdn.example.com/old.m3u8") {
+		t.Fatal("expected stale url to be ignored")
+	}
+	if !r.Invalidate("https://cdn.example.com/live.m3u8") {
+		t.Fatal("expected current url to trigger refresh")
+	}
+	select {
+	case <-r.invalidated:
+	default:
+		t.Fatal("expected pending refresh")
+	}
+
+	r.refreshedAt = time.Now()
+	if r.Invalidate("https://cdn.example.com/live.m3u8") {
+		t.Fatal("expected recent refresh to suppress invalidation")
+	}
+}
@@ -0,0 +1,304 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize 为待导出 span 的队列长度，队列满时丢弃新 span，避免导出缓慢拖慢请求。
+	queueSize = 4096
+	// batchSize 为单次导出的最大 span 数。
+	batchSize = 512
+	// flushInterval 为定时导出间隔。
+	flushInterval = 5 * time.Second
+	// exportTimeout 限制单次导出耗时。
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter 将一批已结束的 span 写出到外部系统。
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Close() error
+}
+
+// batcher 在后台按批量或定时导出 span。
+type batcher struct {
+	exporter Exporter
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newBatcher(exporter Exporter) *batcher {
+	b := &batcher{
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// enqueue 放入导出队列，队列已满或已关闭时丢弃。
+func (b *batcher) enqueue(data SpanData) {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	select {
+	case b.queue <- data:
+	default:
+	}
+}
+
+// run 收集 span 并在达到批量、定时或关闭时导出。
+func (b *batcher) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		// 导出失败只丢弃本批，追踪数据不影响服务。
+		_ = b.exporter.Export(ctx, batch)
+		cancel()
+		batch = make([]SpanData, 0, batchSize)
+	}
+	for {
+		select {
+		case data := <-b.queue:
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-b.flush:
+			for drained := false; !drained; {
+				select {
+				case data := <-b.queue:
+					batch = append(batch, data)
+					if len(batch) >= batchSize {
+						export()
+					}
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(ack)
+			return
+		}
+	}
+}
+
+// shutdown 导出队列中剩余的 span 并关闭导出器，ctx 到期时放弃等待。
+func (b *batcher) shutdown(ctx context.Context) error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		ack := make(chan struct{})
+		select {
+		case b.flush <- ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		select {
+		case <-ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		err = b.exporter.Close()
+	})
+	return err
+}
+
+// FileExporter 将每批 span 以一行 OTLP/JSON（ExportTraceServiceRequest）追加写入文件。
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter 以追加方式打开 path。
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file failed: %w", err)
+	}
+	return &FileExporter{file: f}, nil
+}
+
+// Export 写入一行 JSON。
+func (e *FileExporter) Export(_ context.Context, spans []SpanData) error {
+	data, err := json.Marshal(encodeOTLP(spans))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close 关闭文件。
+func (e *FileExporter) Close() error {
+	return e.file.Close()
+}
+
+// OTLPExporter 通过 OTLP/HTTP JSON 将 span 发送到采集器（如 http://127.0.0.1:4318/v1/traces）。
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPExporter 创建 OTLP/HTTP 导出器，采集器通常位于本机，因此不经过回源策略客户端。
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{endpoint: endpoint, client: &http.Client{Timeout: exportTimeout}}
+}
+
+// Export 发送一批 span，采集器返回非 2xx 时返回错误。
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	data, err := json.Marshal(encodeOTLP(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close 释放空闲连接。
+func (e *OTLPExporter) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// 以下类型对应 OTLP/JSON 的 ExportTraceServiceRequest，标识为十六进制字符串，64 位整数以字符串表示。
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// encodeOTLP 将 span 转换为 OTLP/JSON 请求体。
+func encodeOTLP(spans []SpanData) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		for _, a := range s.Attrs {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: a.Key, Value: encodeValue(a.Value)})
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		out = append(out, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: encodeValue("pinktide")}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "PinkTide/internal/trace"}, Spans: out}},
+	}}}
+}
+
+// encodeValue 按类型转换属性值，未知类型按字符串输出。
+func encodeValue(v any) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpValue{StringValue: &s}
+	}
+}
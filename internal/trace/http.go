@@ -0,0 +1,38 @@
+package trace
+
+import (
+	"net/http"
+
+	"PinkTide/internal/httputil"
+)
+
+// Middleware 为每个请求创建服务端 span 并写入请求 ctx：请求携带合法的 traceparent 时沿用上游追踪与采样标记，
+// route 为注册路由，用作 span 名称以控制基数。
+func Middleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = ContextWithRemote(ctx, sc)
+		}
+		ctx, span := Start(ctx, r.Method+" "+route, KindServer)
+		defer span.End()
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("url.path", r.URL.Path)
+
+		rec := httputil.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		status := rec.Status()
+		span.SetAttr("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(statusError(status))
+		}
+	})
+}
+
+// statusError 将 5xx 状态码记为 span 错误。
+type statusError int
+
+func (e statusError) Error() string {
+	return http.StatusText(int(e))
+}
@@ -0,0 +1,335 @@
+// Package trace 提供轻量的请求追踪：W3C traceparent 传播、span 上下文与批量导出（OTLP/HTTP JSON 或本地 JSON 文件）。
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID 为 16 字节的追踪标识。
+type TraceID [16]byte
+
+// SpanID 为 8 字节的 span 标识。
+type SpanID [8]byte
+
+// String 返回小写十六进制形式。
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid 判断是否为非全零标识。
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String 返回小写十六进制形式。
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid 判断是否为非全零标识。
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext 为可跨进程传播的 span 标识与采样标记。
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid 判断追踪与 span 标识是否均有效。
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent 返回 W3C traceparent 头的值。
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent 解析 W3C traceparent 头，格式错误、版本为 ff 或标识全零时返回 false。
+// 未知的更高版本按 00 版本的前缀解析，符合规范的向前兼容要求。
+func ParseTraceparent(header string) (SpanContext, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || (len(header) > 55 && header[55] != '-') {
+		return SpanContext{}, false
+	}
+	version, traceID, spanID, flags := header[0:2], header[3:35], header[36:52], header[53:55]
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return SpanContext{}, false
+	}
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(header) != 55) {
+		return SpanContext{}, false
+	}
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	_, _ = hex.Decode(sc.TraceID[:], []byte(traceID))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(spanID))
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	b, _ := hex.DecodeString(flags)
+	sc.Sampled = b[0]&0x01 == 1
+	return sc, true
+}
+
+// isLowerHex 判断字符串是否只包含小写十六进制字符。
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Kind 为 span 类型，取值与 OTLP 一致。
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr 为 span 属性。
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span 为一次进行中的操作，方法对 nil 接收者安全。
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+// SpanData 为已结束 span 的只读快照，供导出器使用。
+type SpanData struct {
+	SpanContext SpanContext
+	Parent      SpanID
+	Name        string
+	Kind        Kind
+	Start       time.Time
+	End         time.Time
+	Attrs       []Attr
+	Error       string
+}
+
+// SpanContext 返回 span 的传播标识。
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttr 设置属性，同名属性保留最后一次的值。
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].Key == key {
+			s.attrs[i].Value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, Attr{Key: key, Value: value})
+}
+
+// SetError 将 span 标记为失败，err 为 nil 时忽略。
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束 span，采样的 span 交给导出器；重复调用无效。
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		SpanContext: s.sc,
+		Parent:      s.parent,
+		Name:        s.name,
+		Kind:        s.kind,
+		Start:       s.start,
+		End:         end,
+		Attrs:       append([]Attr(nil), s.attrs...),
+		Error:       s.err,
+	}
+	s.mu.Unlock()
+	if s.sc.Sampled && s.tracer != nil {
+		s.tracer.export(data)
+	}
+}
+
+// Tracer 负责生成 span 并按采样率导出，exporter 为 nil 时只生成标识用于日志关联。
+type Tracer struct {
+	ratio   float64
+	batcher *batcher
+}
+
+// NewTracer 创建追踪器，ratio 为新建追踪的采样比例（0~1），携带 traceparent 的请求沿用上游采样标记。
+func NewTracer(exporter Exporter, ratio float64) *Tracer {
+	t := &Tracer{ratio: ratio}
+	if exporter != nil {
+		t.batcher = newBatcher(exporter)
+	}
+	return t
+}
+
+// Shutdown 导出剩余 span 并关闭导出器。
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.batcher == nil {
+		return nil
+	}
+	return t.batcher.shutdown(ctx)
+}
+
+// export 将结束的 span 放入导出队列。
+func (t *Tracer) export(data SpanData) {
+	if t.batcher != nil {
+		t.batcher.enqueue(data)
+	}
+}
+
+// sample 按追踪标识的低 8 字节决定新建追踪是否采样，同一追踪在各处结论一致。
+func (t *Tracer) sample(id TraceID) bool {
+	if t.batcher == nil || t.ratio <= 0 {
+		return false
+	}
+	if t.ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.ratio
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(nil, 0))
+}
+
+// SetDefault 替换进程级追踪器，返回原追踪器以便关闭。
+func SetDefault(t *Tracer) *Tracer {
+	return defaultTracer.Swap(t)
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+type backgroundKey struct{}
+
+// Background 标记 ctx 属于后台循环：其中没有父级 span 时 Start 创建的根 span 不采样，
+// 避免轮询与刷新每轮产生一条追踪；经 ContextWithRemote 挂在请求之下的调用照常采样。
+func Background(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// Start 以 ctx 中的 span（或远端 span 上下文）为父级创建 span，并返回携带该 span 的 ctx；调用方需调用 End。
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled && t.batcher != nil
+		span.parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = ctx.Value(backgroundKey{}) == nil && t.sample(span.sc.TraceID)
+	}
+	span.sc.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext 返回 ctx 中的当前 span，不存在时返回 nil（nil span 的方法均可安全调用）。
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote 将远端或异步任务的父级 span 上下文写入 ctx，后续 Start 以其为父级。
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	// 屏蔽 ctx 中已有的本地 span，使远端上下文成为父级。
+	ctx = context.WithValue(ctx, spanKey{}, (*Span)(nil))
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前 span 的上下文，没有本地 span 时返回远端上下文。
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := FromContext(ctx); span != nil {
+		return span.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// LogFields 返回 ctx 中追踪标识的日志字段，没有有效的 span 上下文时返回空。
+func LogFields(ctx context.Context) []any {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []any{"trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String()}
+}
+
+// newTraceID 生成随机追踪标识。
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID 生成随机 span 标识。
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
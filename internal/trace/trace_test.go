@@ -0,0 +1,168 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected span context: %+v %v", sc, ok)
+	}
+	if got := sc.Traceparent(); got != header {
+		t.Fatalf("unexpected traceparent: %s", got)
+	}
+
+	invalid := []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, h := range invalid {
+		if _, ok := ParseTraceparent(h); ok {
+			t.Fatalf("expected %q to be rejected", h)
+		}
+	}
+	// 更高版本允许附加字段。
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok {
+		t.Fatal("expected future version to be accepted")
+	}
+}
+
+func TestStartInheritsParent(t *testing.T) {
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, parent := Start(ContextWithRemote(context.Background(), remote), "parent", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+	if parent.SpanContext().TraceID != remote.TraceID || parent.parent != remote.SpanID {
+		t.Fatalf("expected parent to continue remote trace: %+v", parent.SpanContext())
+	}
+	if child.SpanContext().TraceID != remote.TraceID || child.parent != parent.SpanContext().SpanID {
+		t.Fatalf("expected child of parent span: %+v", child.SpanContext())
+	}
+
+	// ContextWithRemote 覆盖 ctx 中已有的本地 span。
+	other, _ := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if got := SpanContextFromContext(ContextWithRemote(ctx, other)); got != other {
+		t.Fatalf("expected remote context to take precedence: %+v", got)
+	}
+}
+
+func TestMiddlewareExportsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("open exporter failed: %v", err)
+	}
+	tracer := NewTracer(exporter, 1)
+	previous := SetDefault(tracer)
+	defer SetDefault(previous)
+
+	var inner SpanContext
+	handler := Middleware("/live.m3u8", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "origin.Get", KindClient)
+		inner = span.SpanContext()
+		span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/live.m3u8?room_id=1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open trace file failed: %v", err)
+	}
+	defer f.Close()
+	var spans []otlpSpan
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("decode line failed: %v", err)
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	client, server := spans[0], spans[1]
+	if server.Name != "GET /live.m3u8" || server.Kind != KindServer || server.ParentSpanID != "00f067aa0ba902b7" || server.Status.Code != 2 {
+		t.Fatalf("unexpected server span: %+v", server)
+	}
+	if client.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || client.SpanID != inner.SpanID.String() || client.ParentSpanID != server.SpanID {
+		t.Fatalf("unexpected client span: %+v", client)
+	}
+}
+
+func TestUnsampledTraceIsNotExported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("open exporter failed: %v", err)
+	}
+	tracer := NewTracer(exporter, 1)
+	previous := SetDefault(tracer)
+	defer SetDefault(previous)
+
+	handler := Middleware("/seg", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !SpanContextFromContext(r.Context()).IsValid() {
+			t.Error("expected span ids for log correlation")
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/seg", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("expected empty trace file: %v %v", info, err)
+	}
+}
+
+func TestBackgroundDoesNotSampleRoots(t *testing.T) {
+	tracer := NewTracer(NewOTLPExporter("http://127.0.0.1:0"), 1)
+	previous := SetDefault(tracer)
+	defer SetDefault(previous)
+	defer tracer.Shutdown(context.Background())
+
+	ctx := Background(context.Background())
+	if _, root := Start(ctx, "poll", KindInternal); root.SpanContext().Sampled {
+		t.Fatal("expected background root span not to be sampled")
+	}
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, child := Start(ContextWithRemote(ctx, remote), "first poll", KindInternal); !child.SpanContext().Sampled {
+		t.Fatal("expected span under a request to follow its sampling")
+	}
+}
+
+func TestLogFieldsFollowRemoteParent(t *testing.T) {
+	if fields := LogFields(context.Background()); fields != nil {
+		t.Fatalf("expected no fields without a span, got %v", fields)
+	}
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	fields := LogFields(ContextWithRemote(Background(context.Background()), remote))
+	want := []any{"trace_id", "4bf92f3577b34da6a3ce929d0e0e4736", "span_id", "00f067aa0ba902b7"}
+	if len(fields) != len(want) {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("unexpected fields %v", fields)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnsigned 表示请求缺少签名参数。
+	ErrUnsigned = errors.New("payload unsigned")
+	// ErrUnknownKey 表示签名使用的密钥不在当前密钥列表中。
+	ErrUnknownKey = errors.New("payload key unknown")
+	// ErrBadSignature 表示签名或过期时间被篡改。
+	ErrBadSignature = errors.New("payload signature invalid")
+	// ErrExpired 表示签名已过期。
+	ErrExpired = errors.New("payload expired")
+)
+
+// Key 为一组带标识的签名密钥，标识写入 URL 用于轮换时选择校验密钥。
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer 使用 HMAC-SHA256 为切片 payload 签名，首个密钥用于签发，全部密钥用于校验。
+type Signer struct {
+	keys []Key
+	byID map[string][]byte
+	ttl  time.Duration
+	step time.Duration
+	now  func() time.Time
+}
+
+// Params 为附加在 payload 之后的签名参数。
+type Params struct {
+	Expires   int64
+	KeyID     string
+	Signature string
+}
+
+// Encode 按固定顺序输出查询参数，保证相同签名得到相同 URL。
+func (p Params) Encode() string {
+	return "exp=" + strconv.FormatInt(p.Expires, 10) +
+		"&kid=" + url.QueryEscape(p.KeyID) +
+		"&sig=" + p.Signature
+}
+
+// New 创建签名器，keys 至少包含一个密钥，ttl 为签名最短有效期。
+func New(keys []Key, ttl time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("signing keys are empty")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("signing ttl must be positive")
+	}
+	byID := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, fmt.Errorf("signing key is invalid")
+		}
+		if _, exists := byID[key.ID]; exists {
+			return nil, fmt.Errorf("signing key id duplicated: %s", key.ID)
+		}
+		byID[key.ID] = key.Secret
+	}
+	// 过期时间按粒度对齐，同一切片在粒度内生成相同 URL，避免 CDN 缓存键频繁变化。
+	step := ttl / 4
+	if step < time.Second {
+		step = time.Second
+	}
+	return &Signer{keys: keys, byID: byID, ttl: ttl, step: step, now: time.Now}, nil
+}
+
+// ParseKeys 解析 "id:secret,id:secret" 形式的密钥列表，首个为当前签发密钥。
+func ParseKeys(raw string) ([]Key, error) {
+	var keys []Key
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(part, ":")
+		id = strings.TrimSpace(id)
+		secret = strings.TrimSpace(secret)
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("signing key must be id:secret")
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// RandomKey 生成进程内临时密钥，仅用于未配置密钥的单实例部署。
+func RandomKey() (Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("generate signing key failed: %w", err)
+	}
+	return Key{ID: "tmp", Secret: secret}, nil
+}
+
+// Sign 使用当前签发密钥为 payload 生成签名参数。
+func (s *Signer) Sign(payload string) Params {
+	expires := s.now().Add(s.ttl).Truncate(s.step).Add(s.step).Unix()
+	key := s.keys[0]
+	return Params{
+		Expires:   expires,
+		KeyID:     key.ID,
+		Signature: sign(key.Secret, key.ID, expires, payload),
+	}
+}
+
+// SignPersistent 使用当前签发密钥为 payload 生成不过期的签名参数（exp=0），用于播放器在整个会话中
+// 反复加载的地址；签名同样覆盖 payload，签发密钥移出密钥列表后失效。
+func (s *Signer) SignPersistent(payload string) Params {
+	key := s.keys[0]
+	return Params{
+		Expires:   0,
+		KeyID:     key.ID,
+		Signature: sign(key.Secret, key.ID, 0, payload),
+	}
+}
+
+// Verify 校验 query 中的签名参数，缺失、篡改与过期分别返回不同错误。
+func (s *Signer) Verify(payload string, query url.Values) error {
+	expRaw := query.Get("exp")
+	keyID := query.Get("kid")
+	signature := query.Get("sig")
+	if expRaw == "" && keyID == "" && signature == "" {
+		return ErrUnsigned
+	}
+	if expRaw == "" || keyID == "" || signature == "" {
+		return ErrBadSignature
+	}
+	secret, ok := s.byID[keyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	expires, err := strconv.ParseInt(expRaw, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	want := sign(secret, keyID, expires, payload)
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return ErrBadSignature
+	}
+	if expires != 0 && s.now().Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign 计算签名，覆盖密钥标识、过期时间与 payload。
+func sign(secret []byte, keyID string, expires int64, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(keyID))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
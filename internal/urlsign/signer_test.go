@@ -0,0 +1,121 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer, err := New([]Key{{ID: "k1", Secret: []byte("secret-1")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	signer.now = func() time.Time { return now }
+
+	payload := "aHR0cHM6Ly9vcmlnaW4uZXhhbXBsZS5jb20vc2VnLnRz"
+	params := signer.Sign(payload)
+	if params.KeyID != "k1" {
+		t.Fatalf("unexpected key id: %s", params.KeyID)
+	}
+	if params.Expires <= now.Add(time.Hour).Unix() {
+		t.Fatalf("expiry shorter than ttl: %d", params.Expires)
+	}
+	if again := signer.Sign(payload); again != params {
+		t.Fatalf("expected stable signature within step")
+	}
+
+	query, _ := url.ParseQuery(params.Encode())
+	if err := signer.Verify(payload, query); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(url.Values)
+		want   error
+	}{
+		{name: "unsigned", mutate: func(q url.Values) { q.Del("exp"); q.Del("kid"); q.Del("sig") }, want: ErrUnsigned},
+		{name: "missing sig", mutate: func(q url.Values) { q.Del("sig") }, want: ErrBadSignature},
+		{name: "tampered exp", mutate: func(q url.Values) { q.Set("exp", "9999999999") }, want: ErrBadSignature},
+		{name: "tampered sig", mutate: func(q url.Values) { q.Set("sig", "AAAA") }, want: ErrBadSignature},
+		{name: "unknown key", mutate: func(q url.Values) { q.Set("kid", "k9") }, want: ErrUnknownKey},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(params.Encode())
+			tc.mutate(q)
+			if err := signer.Verify(payload, q); !errors.Is(err, tc.want) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	if err := signer.Verify(payload+"x", query); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected tampered payload to fail: %v", err)
+	}
+
+	now = time.Unix(params.Expires, 0)
+	if err := signer.Verify(payload, query); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected expired: %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := New([]Key{{ID: "old", Secret: []byte("old-secret")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	keys, err := ParseKeys("new:new-secret, old:old-secret")
+	if err != nil {
+		t.Fatalf("parse keys failed: %v", err)
+	}
+	rotated, err := New(keys, time.Hour)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+
+	payload := "cGF5bG9hZA=="
+	query, _ := url.ParseQuery(old.Sign(payload).Encode())
+	if err := rotated.Verify(payload, query); err != nil {
+		t.Fatalf("expected old key to verify after rotation: %v", err)
+	}
+	if got := rotated.Sign(payload).KeyID; got != "new" {
+		t.Fatalf("expected new key to sign, got %s", got)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	if _, err := ParseKeys("missing-separator"); err == nil {
+		t.Fatalf("expected error for key without id")
+	}
+	keys, err := ParseKeys("")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected empty keys, got %v, %v", keys, err)
+	}
+}
+
+func TestSignPersistentDoesNotExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer, err := New([]Key{{ID: "k1", Secret: []byte("secret-1")}}, time.Hour)
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	signer.now = func() time.Time { return now }
+
+	payload := "aHR0cHM6Ly9vcmlnaW4uZXhhbXBsZS5jb20vaW5kZXgubTN1OA=="
+	query, _ := url.ParseQuery(signer.SignPersistent(payload).Encode())
+	now = now.Add(365 * 24 * time.Hour)
+	if err := signer.Verify(payload, query); err != nil {
+		t.Fatalf("expected persistent signature to stay valid: %v", err)
+	}
+	if err := signer.Verify(payload+"x", query); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected tampered payload to be rejected, got %v", err)
+	}
+	query.Set("exp", "1")
+	if err := signer.Verify(payload, query); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected changed expiry to be rejected, got %v", err)
+	}
+}